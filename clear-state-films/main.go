@@ -2,6 +2,10 @@ package main
 
 import (
 	"context"
+	"errors"
+	"finder/common/idempotency"
+	"finder/common/profile"
+	"fmt"
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go/aws"
@@ -9,40 +13,84 @@ import (
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/google/uuid"
 	"log"
+	"slices"
+	"strconv"
+	"time"
 )
 
 var sess = session.Must(session.NewSession())
 var db = dynamodb.New(sess)
 
+// Cleared profiles are kept in 'user_films_deleted' for restoreWindow, so that restore-state-films can bring
+// them back. Every clear keeps its own snapshot, keyed by the user id and 'deletedAt' in milliseconds, so a later
+// clear does not overwrite an earlier one. Items there expire via the 'expiresAt' TTL attribute.
+var deletedTable = "user_films_deleted"
+var restoreWindow = 7 * 24 * time.Hour
+
+var maxRetries = 3
+
+// scopeLists are the user_films lists cleared by every scope. There is no watchlist in user_films, so there is
+// no scope for it.
+var scopeLists = map[string][]string{
+	"liked":   {"likedFilms"},
+	"unliked": {"unlikedFilms"},
+	"history": {"recommendedFilms"},
+	"all":     {"likedFilms", "unlikedFilms", "recommendedFilms"},
+}
+
 func main() {
-	lambda.Start(handleRequest)
+	lambda.Start(idempotency.Wrap("clear-state-films", handleRequest))
 }
 
 func handleRequest(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 	userId, err := getUserIdAndVerify(req)
 	if err != nil {
 		id := req.QueryStringParameters["id"]
-		log.Fatalf("Provided user id is not correct, user id - %s", id)
+		log.Printf("Provided user id is not correct, user id - %s", id)
 		return events.APIGatewayProxyResponse{
 			StatusCode: 400,
 			Body:       "Provided user id is not correct, user id - " + id,
-		}, err
+		}, nil
 	}
 
-	db.DeleteItem(&dynamodb.DeleteItemInput{
-		TableName: aws.String("user_films"),
-		Key: map[string]*dynamodb.AttributeValue{
-			"id": {
-				S: aws.String(userId),
-			},
-		},
-	})
+	scope := req.QueryStringParameters["scope"]
+	if scope == "" {
+		scope = "all"
+	}
+	lists, ok := scopeLists[scope]
+	if !ok {
+		log.Printf("Provided scope is not correct, scope - %s", scope)
+		return events.APIGatewayProxyResponse{
+			StatusCode: 400,
+			Body:       "Provided scope is not correct, expected 'liked', 'unliked', 'history' or 'all', there is no watchlist to clear, scope - " + scope,
+		}, nil
+	}
+
+	from, to, err := getDateRange(req)
+	if err != nil {
+		log.Printf("Date range params are not correct: %v", err)
+		return events.APIGatewayProxyResponse{
+			StatusCode: 400,
+			Body:       "Date range params are not correct: " + err.Error(),
+		}, nil
+	}
+
+	var clearedLists []string
+	if scope == "all" && from == nil && to == nil {
+		clearedLists, err = softDelete(userId)
+	} else {
+		clearedLists, err = clearLists(userId, lists, from, to)
+	}
 	if err != nil {
-		log.Fatalf("Got error calling DeleteItemInput: %s", err)
+		log.Printf("Got error clearing user films: %s", err)
 		return events.APIGatewayProxyResponse{
 			StatusCode: 500,
-			Body:       "Got error calling DeleteItemInput: " + err.Error(),
-		}, err
+			Body:       "Got error clearing user films: " + err.Error(),
+		}, nil
+	}
+	//recommendations depend only on rated films, clearing the history does not change them
+	if slices.Contains(clearedLists, "likedFilms") || slices.Contains(clearedLists, "unlikedFilms") {
+		profile.PublishChanged(userId, profile.Cleared, nil)
 	}
 
 	return events.APIGatewayProxyResponse{
@@ -55,3 +103,277 @@ func getUserIdAndVerify(req events.APIGatewayProxyRequest) (string, error) {
 
 	return userId.String(), err
 }
+
+// softDelete moves the user_films item into the deleted table and removes it in one transaction, returning
+// the lists which had films. The removal is conditional on the item being unchanged since it was read,
+// so a film rated meanwhile is not lost.
+func softDelete(userId string) ([]string, error) {
+	key := map[string]*dynamodb.AttributeValue{
+		"id": {
+			S: aws.String(userId),
+		},
+	}
+
+	for attempts := 0; attempts < maxRetries; attempts++ {
+		result, err := db.GetItem(&dynamodb.GetItemInput{
+			TableName:      aws.String("user_films"),
+			Key:            key,
+			ConsistentRead: aws.Bool(true),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("got error calling GetItem: %w", err)
+		}
+		if result.Item == nil {
+			return nil, nil
+		}
+
+		var clearedLists []string
+		for _, list := range scopeLists["all"] {
+			if result.Item[list] != nil && len(result.Item[list].L) > 0 {
+				clearedLists = append(clearedLists, list)
+			}
+		}
+
+		conditionExpression, names, values := unchangedItemCondition(result.Item)
+		_, err = db.TransactWriteItems(&dynamodb.TransactWriteItemsInput{
+			TransactItems: []*dynamodb.TransactWriteItem{
+				{
+					Put: snapshotPut(userId, result.Item, scopeLists["all"]),
+				},
+				{
+					Delete: &dynamodb.Delete{
+						TableName:                 aws.String("user_films"),
+						Key:                       key,
+						ConditionExpression:       aws.String(conditionExpression),
+						ExpressionAttributeNames:  names,
+						ExpressionAttributeValues: values,
+					},
+				},
+			},
+		})
+		if err == nil {
+			return clearedLists, nil
+		}
+
+		var canceled *dynamodb.TransactionCanceledException
+		if !errors.As(err, &canceled) || attempts == maxRetries-1 {
+			return nil, fmt.Errorf("got error calling TransactWriteItems: %w", err)
+		}
+	}
+
+	return nil, nil
+}
+
+// snapshotPut keeps the item in the deleted table until the restore window passes, together with the cleared lists,
+// so that only those are restored. The put fails instead of overwriting a snapshot of the same millisecond,
+// the transaction is then retried.
+func snapshotPut(userId string, item map[string]*dynamodb.AttributeValue, lists []string) *dynamodb.Put {
+	now := time.Now()
+
+	return &dynamodb.Put{
+		TableName: aws.String(deletedTable),
+		Item: map[string]*dynamodb.AttributeValue{
+			"id": {
+				S: aws.String(userId),
+			},
+			"deletedAt": {
+				N: aws.String(strconv.FormatInt(now.UnixMilli(), 10)),
+			},
+			"snapshot": {
+				M: item,
+			},
+			"lists": {
+				SS: aws.StringSlice(lists),
+			},
+			"expiresAt": {
+				N: aws.String(strconv.FormatInt(now.Add(restoreWindow).Unix(), 10)),
+			},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(id)"),
+	}
+}
+
+// clearLists removes films rated within the range from the lists, or all of their films when there is no range,
+// returning the lists which had films removed. Only the lists are updated, conditionally on them being unchanged
+// since they were read. The item before the update is kept in the deleted table the same way as by softDelete,
+// so it can be restored.
+func clearLists(userId string, lists []string, from *time.Time, to *time.Time) ([]string, error) {
+	key := map[string]*dynamodb.AttributeValue{
+		"id": {
+			S: aws.String(userId),
+		},
+	}
+
+	for attempts := 0; attempts < maxRetries; attempts++ {
+		result, err := db.GetItem(&dynamodb.GetItemInput{
+			TableName:      aws.String("user_films"),
+			Key:            key,
+			ConsistentRead: aws.Bool(true),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("got error calling GetItem: %w", err)
+		}
+		if result.Item == nil {
+			return nil, nil
+		}
+
+		conditionExpression := "attribute_exists(id)"
+		updateExpression := ""
+		names := map[string]*string{}
+		values := map[string]*dynamodb.AttributeValue{}
+		var clearedLists []string
+		for index, list := range lists {
+			name := fmt.Sprintf("#l%d", index)
+			names[name] = aws.String(list)
+
+			var oldEntries []*dynamodb.AttributeValue
+			if result.Item[list] != nil {
+				oldEntries = result.Item[list].L
+				conditionExpression += fmt.Sprintf(" AND %s = :old%d", name, index)
+				values[fmt.Sprintf(":old%d", index)] = result.Item[list]
+			} else {
+				conditionExpression += fmt.Sprintf(" AND attribute_not_exists(%s)", name)
+			}
+
+			newEntries := removeRatedWithin(oldEntries, from, to)
+			if len(newEntries) != len(oldEntries) {
+				clearedLists = append(clearedLists, list)
+			}
+			if updateExpression != "" {
+				updateExpression += ", "
+			}
+			updateExpression += fmt.Sprintf("%s = :new%d", name, index)
+			values[fmt.Sprintf(":new%d", index)] = &dynamodb.AttributeValue{L: newEntries}
+		}
+		if len(clearedLists) == 0 {
+			return nil, nil
+		}
+
+		_, err = db.TransactWriteItems(&dynamodb.TransactWriteItemsInput{
+			TransactItems: []*dynamodb.TransactWriteItem{
+				{
+					Put: snapshotPut(userId, result.Item, lists),
+				},
+				{
+					Update: &dynamodb.Update{
+						TableName:                 aws.String("user_films"),
+						Key:                       key,
+						ConditionExpression:       aws.String(conditionExpression),
+						UpdateExpression:          aws.String("SET " + updateExpression),
+						ExpressionAttributeNames:  names,
+						ExpressionAttributeValues: values,
+					},
+				},
+			},
+		})
+		if err == nil {
+			return clearedLists, nil
+		}
+
+		var canceled *dynamodb.TransactionCanceledException
+		if !errors.As(err, &canceled) || attempts == maxRetries-1 {
+			return nil, fmt.Errorf("got error calling TransactWriteItems: %w", err)
+		}
+	}
+
+	return nil, nil
+}
+
+// removeRatedWithin keeps entries rated outside the range. Entries without time, like legacy plain strings,
+// are kept when there is a range and are removed together with everything else when there is none.
+func removeRatedWithin(entries []*dynamodb.AttributeValue, from *time.Time, to *time.Time) []*dynamodb.AttributeValue {
+	kept := []*dynamodb.AttributeValue{}
+	if from == nil && to == nil {
+		return kept
+	}
+
+	for _, entry := range entries {
+		ratedAt, ok := entryRatedAt(entry)
+		if !ok || (from != nil && ratedAt.Before(*from)) || (to != nil && ratedAt.After(*to)) {
+			kept = append(kept, entry)
+		}
+	}
+
+	return kept
+}
+
+// entryRatedAt returns the time of the rating, or of the recommendation for history entries
+func entryRatedAt(entry *dynamodb.AttributeValue) (time.Time, bool) {
+	ratedAt, ok := entry.M["ratedAt"]
+	if !ok || ratedAt.N == nil {
+		ratedAt, ok = entry.M["recommendedAt"]
+	}
+	if !ok || ratedAt.N == nil {
+		return time.Time{}, false
+	}
+
+	seconds, err := strconv.ParseInt(*ratedAt.N, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(seconds, 0), true
+}
+
+// getDateRange parses optional 'from' and 'to' params, either dates (2006-01-02) or RFC 3339 timestamps.
+// A date in 'to' is inclusive, so it is moved to the end of that day.
+func getDateRange(req events.APIGatewayProxyRequest) (*time.Time, *time.Time, error) {
+	from, err := parseDateParam(req.QueryStringParameters["from"], false)
+	if err != nil {
+		return nil, nil, fmt.Errorf("from - %w", err)
+	}
+	to, err := parseDateParam(req.QueryStringParameters["to"], true)
+	if err != nil {
+		return nil, nil, fmt.Errorf("to - %w", err)
+	}
+	if from != nil && to != nil && from.After(*to) {
+		return nil, nil, fmt.Errorf("from %s is after to %s", from.Format(time.RFC3339), to.Format(time.RFC3339))
+	}
+
+	return from, to, nil
+}
+
+func parseDateParam(value string, endOfDay bool) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	if date, err := time.Parse(time.DateOnly, value); err == nil {
+		if endOfDay {
+			date = date.Add(24*time.Hour - time.Second)
+		}
+		return &date, nil
+	}
+
+	timestamp, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, err
+	}
+
+	return &timestamp, nil
+}
+
+// unchangedItemCondition builds a condition that every attribute of the item still has the read value
+func unchangedItemCondition(item map[string]*dynamodb.AttributeValue) (string, map[string]*string, map[string]*dynamodb.AttributeValue) {
+	names := map[string]*string{}
+	values := map[string]*dynamodb.AttributeValue{}
+	conditionExpression := "attribute_exists(id)"
+
+	index := 0
+	for attribute, value := range item {
+		if attribute == "id" {
+			continue
+		}
+
+		name := fmt.Sprintf("#a%d", index)
+		placeholder := fmt.Sprintf(":v%d", index)
+		names[name] = aws.String(attribute)
+		values[placeholder] = value
+		conditionExpression += " AND " + name + " = " + placeholder
+		index++
+	}
+
+	if len(names) == 0 {
+		return conditionExpression, nil, nil
+	}
+	return conditionExpression, names, values
+}
@@ -0,0 +1,10 @@
+module finder/common
+
+go 1.21
+
+require (
+	github.com/aws/aws-lambda-go v1.44.0
+	github.com/aws/aws-sdk-go v1.49.22
+)
+
+require github.com/jmespath/go-jmespath v0.4.0 // indirect
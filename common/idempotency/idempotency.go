@@ -0,0 +1,214 @@
+// Package idempotency lets mutating endpoints accept an 'Idempotency-Key' header
+package idempotency
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Responses to requests with an 'Idempotency-Key' header are kept in 'idempotency_keys' and replayed
+// when the same request is retried. Items expire via the 'expiresAt' TTL attribute.
+var idempotencyTable = "idempotency_keys"
+var idempotencyTtl = 24 * time.Hour
+
+// a request still in progress after this time is considered failed, so a retry may take the key over
+var idempotencyLockTtl = 2 * time.Minute
+
+var db = dynamodb.New(session.Must(session.NewSession()))
+
+type Handler func(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error)
+
+// Wrap runs the handler once per key. Repeated requests get the stored response back, requests
+// with a key used for a different request are rejected. Server errors are not stored, so they can be retried.
+func Wrap(endpoint string, handler Handler) Handler {
+	return func(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		key := getHeader(req, "Idempotency-Key")
+		if key == "" {
+			return handler(ctx, req)
+		}
+
+		recordId := endpoint + "#" + req.QueryStringParameters["id"] + "#" + key
+		requestHash := hashRequest(req)
+		record, err := claimIdempotencyKey(recordId, requestHash)
+		if err != nil {
+			log.Printf("Got error claiming idempotency key %s: %v", key, err)
+			return events.APIGatewayProxyResponse{
+				StatusCode: 500,
+				Body:       "Got error claiming idempotency key: " + err.Error(),
+			}, nil
+		}
+
+		if record != nil {
+			if record["requestHash"] == nil || *record["requestHash"].S != requestHash {
+				return events.APIGatewayProxyResponse{
+					StatusCode: 422,
+					Body:       "Idempotency key was already used for a different request, key - " + key,
+				}, nil
+			}
+			if record["statusCode"] == nil {
+				return events.APIGatewayProxyResponse{
+					StatusCode: 409,
+					Body:       "Request with the same idempotency key is in progress, key - " + key,
+				}, nil
+			}
+
+			return replayResponse(record), nil
+		}
+
+		response, err := handler(ctx, req)
+		if err != nil || response.StatusCode >= 500 {
+			releaseIdempotencyKey(recordId)
+		} else {
+			storeResponse(recordId, response)
+		}
+
+		return response, err
+	}
+}
+
+// claimIdempotencyKey locks the key for this request. If the key is taken, its record is returned instead.
+func claimIdempotencyKey(recordId string, requestHash string) (map[string]*dynamodb.AttributeValue, error) {
+	now := time.Now()
+	_, err := db.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(idempotencyTable),
+		Item: map[string]*dynamodb.AttributeValue{
+			"id": {
+				S: aws.String(recordId),
+			},
+			"requestHash": {
+				S: aws.String(requestHash),
+			},
+			"lockedUntil": {
+				N: aws.String(strconv.FormatInt(now.Add(idempotencyLockTtl).Unix(), 10)),
+			},
+			"expiresAt": {
+				N: aws.String(strconv.FormatInt(now.Add(idempotencyTtl).Unix(), 10)),
+			},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(id) OR (attribute_not_exists(statusCode) AND lockedUntil < :now)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":now": {
+				N: aws.String(strconv.FormatInt(now.Unix(), 10)),
+			},
+		},
+	})
+	if err == nil {
+		return nil, nil
+	}
+
+	var awsErr awserr.Error
+	if !errors.As(err, &awsErr) || awsErr.Code() != dynamodb.ErrCodeConditionalCheckFailedException {
+		return nil, err
+	}
+
+	result, err := db.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(idempotencyTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"id": {
+				S: aws.String(recordId),
+			},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result.Item == nil {
+		return nil, errors.New("idempotency key was released meanwhile, retry the request")
+	}
+
+	return result.Item, nil
+}
+
+func storeResponse(recordId string, response events.APIGatewayProxyResponse) {
+	_, err := db.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(idempotencyTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"id": {
+				S: aws.String(recordId),
+			},
+		},
+		UpdateExpression: aws.String("SET statusCode = :statusCode, body = :body"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":statusCode": {
+				N: aws.String(strconv.Itoa(response.StatusCode)),
+			},
+			":body": {
+				S: aws.String(response.Body),
+			},
+		},
+	})
+	if err != nil {
+		log.Printf("Got error storing idempotent response, record - %s, error - %v", recordId, err)
+	}
+}
+
+func releaseIdempotencyKey(recordId string) {
+	_, err := db.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(idempotencyTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"id": {
+				S: aws.String(recordId),
+			},
+		},
+	})
+	if err != nil {
+		log.Printf("Got error releasing idempotency key, record - %s, error - %v", recordId, err)
+	}
+}
+
+func replayResponse(record map[string]*dynamodb.AttributeValue) events.APIGatewayProxyResponse {
+	statusCode, _ := strconv.Atoi(*record["statusCode"].N)
+	response := events.APIGatewayProxyResponse{
+		StatusCode: statusCode,
+		Headers: map[string]string{
+			"Idempotent-Replayed": "true",
+		},
+	}
+	if record["body"] != nil && record["body"].S != nil {
+		response.Body = *record["body"].S
+	}
+
+	return response
+}
+
+// hashRequest identifies the request by its method, query params and body
+func hashRequest(req events.APIGatewayProxyRequest) string {
+	names := make([]string, 0, len(req.QueryStringParameters))
+	for name := range req.QueryStringParameters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	hash := sha256.New()
+	hash.Write([]byte(req.HTTPMethod + "\n"))
+	for _, name := range names {
+		hash.Write([]byte(name + "=" + req.QueryStringParameters[name] + "\n"))
+	}
+	hash.Write([]byte(req.Body))
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// getHeader finds the header regardless of its case, API Gateway passes headers as the client sent them
+func getHeader(req events.APIGatewayProxyRequest, name string) string {
+	for header, value := range req.Headers {
+		if strings.EqualFold(header, name) {
+			return value
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,108 @@
+// Package profile publishes profile-changed events of users whose films changed
+package profile
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// Profile-changed events tell consumers of the user history, like the recommendation queue of get-films,
+// that films of the user changed, so that recommendations made for the old taste are dropped.
+// Events are sent to the SQS queue of 'ProfileEventsQueueUrl' environment variable, without it they are only logged.
+// The get-films worker gets them only when it is the prefetch queue of the worker.
+
+// Changes of profile-changed events
+const (
+	Liked    = "liked"
+	Unliked  = "unliked"
+	Rated    = "rated"
+	Deleted  = "deleted"
+	Cleared  = "cleared"
+	Restored = "restored"
+	Imported = "imported"
+)
+
+type ChangedEvent struct {
+	Type      string   `json:"type"`
+	Id        string   `json:"id"`
+	Change    string   `json:"change"`
+	Films     []string `json:"films,omitempty"`
+	ChangedAt int64    `json:"changedAt"`
+}
+
+// EventPublisher sends profile-changed events. Another event bus only needs another implementation.
+type EventPublisher interface {
+	Publish(event ChangedEvent) error
+}
+
+var publisher = newEventPublisher()
+
+func newEventPublisher() EventPublisher {
+	queueUrl := os.Getenv("ProfileEventsQueueUrl")
+	if queueUrl == "" {
+		return logPublisher{}
+	}
+
+	return sqsPublisher{
+		client:   sqs.New(session.Must(session.NewSession())),
+		queueUrl: queueUrl,
+	}
+}
+
+// PublishChanged is called after the change is stored. A lost event is only logged, the change is not undone,
+// as consumers check films against the stored history anyway and only keep stale films longer.
+func PublishChanged(userId string, change string, films []string) {
+	event := ChangedEvent{
+		Type:      "profile-changed",
+		Id:        userId,
+		Change:    change,
+		Films:     films,
+		ChangedAt: time.Now().Unix(),
+	}
+
+	err := publisher.Publish(event)
+	if err != nil {
+		log.Printf("Got error publishing profile-changed event, user id - %s, change - %s, error - %v", userId, change, err)
+	}
+}
+
+type sqsPublisher struct {
+	client   *sqs.SQS
+	queueUrl string
+}
+
+func (p sqsPublisher) Publish(event ChangedEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	input := &sqs.SendMessageInput{
+		QueueUrl:    aws.String(p.queueUrl),
+		MessageBody: aws.String(string(body)),
+	}
+	// a FIFO queue keeps events of a user in order and drops an event published twice
+	if strings.HasSuffix(p.queueUrl, ".fifo") {
+		digest := sha256.Sum256(body)
+		input.MessageGroupId = aws.String(event.Id)
+		input.MessageDeduplicationId = aws.String(hex.EncodeToString(digest[:]))
+	}
+
+	_, err = p.client.SendMessage(input)
+	return err
+}
+
+type logPublisher struct{}
+
+func (logPublisher) Publish(event ChangedEvent) error {
+	log.Printf("Profile changed, user id - %s, change - %s, films - %v", event.Id, event.Change, event.Films)
+	return nil
+}
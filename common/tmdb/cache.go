@@ -0,0 +1,218 @@
+package tmdb
+
+import (
+	"encoding/json"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Films found in TMDB are kept in memory for the life of the lambda instance and in the 'tmdb_films' table,
+// so that the same film is not looked up again by any lambda. Table items expire via the 'expiresAt' TTL attribute.
+var cacheTable = "tmdb_films"
+var cacheTtl = 30 * 24 * time.Hour
+
+// cacheVersion is raised when ResultRecommendedFilm gets new fields, items of other versions are looked up again
+var cacheVersion = "2"
+
+var db = dynamodb.New(session.Must(session.NewSession()))
+
+var memoryCache = map[string]ResultRecommendedFilm{}
+var memoryCacheMutex sync.RWMutex
+
+// Lists of films, like films recommended for a film, are kept the same way under keys starting with '#list',
+// for a shorter time, as they change more often than films
+var listCacheTtl = 7 * 24 * time.Hour
+
+var memoryListCache = map[string][]MovieMatch{}
+var memoryListCacheMutex sync.RWMutex
+
+func cacheKey(filmName string, year string) string {
+	key := strings.ToLower(strings.TrimSpace(filmName))
+	if year != "" {
+		key += " (" + year + ")"
+	}
+
+	return key
+}
+
+// idCacheKey is the key of a film looked up by its TMDB id, it never matches a title
+func idCacheKey(movieId int) string {
+	return "#" + strconv.Itoa(movieId)
+}
+
+// listCacheKey is the key of a list of films, for the list name and what it is read for: a film id or genre ids
+func listCacheKey(list string, of string) string {
+	return "#list#" + list + "#" + of
+}
+
+func getCachedFilm(key string) (ResultRecommendedFilm, bool) {
+	memoryCacheMutex.RLock()
+	film, ok := memoryCache[key]
+	memoryCacheMutex.RUnlock()
+	if ok {
+		return film, true
+	}
+
+	result, err := db.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(cacheTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"title": {
+				S: aws.String(key),
+			},
+		},
+	})
+	if err != nil {
+		log.Printf("Got error reading TMDB cache, film - %s, error - %v", key, err)
+		return ResultRecommendedFilm{}, false
+	}
+
+	film, ok = parseCachedFilm(result.Item)
+	if ok {
+		rememberFilm(key, film)
+	}
+	return film, ok
+}
+
+func cacheFilm(key string, film ResultRecommendedFilm) {
+	rememberFilm(key, film)
+
+	bytes, err := json.Marshal(film)
+	if err != nil {
+		log.Printf("Got error marshalling film to TMDB cache, film - %s, error - %v", film.Name, err)
+		return
+	}
+
+	_, err = db.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(cacheTable),
+		Item: map[string]*dynamodb.AttributeValue{
+			"title": {
+				S: aws.String(key),
+			},
+			"film": {
+				S: aws.String(string(bytes)),
+			},
+			"version": {
+				N: aws.String(cacheVersion),
+			},
+			"expiresAt": {
+				N: aws.String(strconv.FormatInt(time.Now().Add(cacheTtl).Unix(), 10)),
+			},
+		},
+	})
+	if err != nil {
+		log.Printf("Got error writing TMDB cache, film - %s, error - %v", film.Name, err)
+	}
+}
+
+func rememberFilm(key string, film ResultRecommendedFilm) {
+	memoryCacheMutex.Lock()
+	memoryCache[key] = film
+	memoryCacheMutex.Unlock()
+}
+
+func parseCachedFilm(item map[string]*dynamodb.AttributeValue) (ResultRecommendedFilm, bool) {
+	if item == nil || item["film"] == nil || item["film"].S == nil {
+		return ResultRecommendedFilm{}, false
+	}
+	if item["version"] == nil || item["version"].N == nil || *item["version"].N != cacheVersion {
+		return ResultRecommendedFilm{}, false
+	}
+
+	var film ResultRecommendedFilm
+	err := json.Unmarshal([]byte(*item["film"].S), &film)
+	if err != nil {
+		log.Printf("Got error parsing TMDB cache item, error - %v", err)
+		return ResultRecommendedFilm{}, false
+	}
+
+	return film, true
+}
+
+func getCachedMovieList(key string) ([]MovieMatch, bool) {
+	memoryListCacheMutex.RLock()
+	movies, ok := memoryListCache[key]
+	memoryListCacheMutex.RUnlock()
+	if ok {
+		return movies, true
+	}
+
+	result, err := db.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(cacheTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"title": {
+				S: aws.String(key),
+			},
+		},
+	})
+	if err != nil {
+		log.Printf("Got error reading TMDB cache, list - %s, error - %v", key, err)
+		return nil, false
+	}
+
+	movies, ok = parseCachedMovieList(result.Item)
+	if ok {
+		rememberMovieList(key, movies)
+	}
+	return movies, ok
+}
+
+func cacheMovieList(key string, movies []MovieMatch) {
+	rememberMovieList(key, movies)
+
+	bytes, err := json.Marshal(movies)
+	if err != nil {
+		log.Printf("Got error marshalling list to TMDB cache, list - %s, error - %v", key, err)
+		return
+	}
+
+	_, err = db.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(cacheTable),
+		Item: map[string]*dynamodb.AttributeValue{
+			"title": {
+				S: aws.String(key),
+			},
+			"movies": {
+				S: aws.String(string(bytes)),
+			},
+			"version": {
+				N: aws.String(cacheVersion),
+			},
+			"expiresAt": {
+				N: aws.String(strconv.FormatInt(time.Now().Add(listCacheTtl).Unix(), 10)),
+			},
+		},
+	})
+	if err != nil {
+		log.Printf("Got error writing TMDB cache, list - %s, error - %v", key, err)
+	}
+}
+
+func rememberMovieList(key string, movies []MovieMatch) {
+	memoryListCacheMutex.Lock()
+	memoryListCache[key] = movies
+	memoryListCacheMutex.Unlock()
+}
+
+func parseCachedMovieList(item map[string]*dynamodb.AttributeValue) ([]MovieMatch, bool) {
+	if item == nil || item["movies"] == nil || item["movies"].S == nil {
+		return nil, false
+	}
+	if item["version"] == nil || item["version"].N == nil || *item["version"].N != cacheVersion {
+		return nil, false
+	}
+
+	var movies []MovieMatch
+	err := json.Unmarshal([]byte(*item["movies"].S), &movies)
+	if err != nil {
+		log.Printf("Got error parsing TMDB cache item, error - %v", err)
+		return nil, false
+	}
+
+	return movies, true
+}
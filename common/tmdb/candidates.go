@@ -0,0 +1,133 @@
+package tmdb
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var movieRecommendationsUrl = "https://api.themoviedb.org/3/movie/{movie_id}/recommendations?language=en-US&page=1"
+var movieSimilarUrl = "https://api.themoviedb.org/3/movie/{movie_id}/similar?language=en-US&page=1"
+var movieDiscoverUrl = "https://api.themoviedb.org/3/discover/movie?language=en-US&page=1&include_adult=false&sort_by=popularity.desc&vote_count.gte=200&with_genres={genres}"
+var genreListUrl = "https://api.themoviedb.org/3/genre/movie/list?language=en-US"
+
+var genreIds map[string]int
+var genreIdsMutex sync.Mutex
+
+// RelatedMovies are the films TMDB recommends to people who watched a film, the best first,
+// and the films with genres and keywords like it, the most similar first
+type RelatedMovies struct {
+	Recommendations []MovieMatch
+	Similar         []MovieMatch
+}
+
+// GetRelatedMovies reads related films of several films at once, at most maxConcurrentLookups requests at a time.
+// Lists which cannot be read are logged and left empty.
+func GetRelatedMovies(movieIds []int) map[int]RelatedMovies {
+	related := make(map[int]RelatedMovies, len(movieIds))
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	semaphore := make(chan struct{}, maxConcurrentLookups)
+
+	read := func(movieId int, list string, listUrl string, set func(movies *RelatedMovies, list []MovieMatch)) {
+		defer wg.Done()
+		defer func() { <-semaphore }()
+
+		movies, err := getMovieList(listCacheKey(list, strconv.Itoa(movieId)), strings.ReplaceAll(listUrl, "{movie_id}", strconv.Itoa(movieId)))
+		if err != nil {
+			log.Printf("Got error getting TMDB %s, movie id - %d, error - %v", list, movieId, err)
+			return
+		}
+
+		mutex.Lock()
+		movieLists := related[movieId]
+		set(&movieLists, movies)
+		related[movieId] = movieLists
+		mutex.Unlock()
+	}
+
+	for _, movieId := range movieIds {
+		wg.Add(2)
+		semaphore <- struct{}{}
+		go read(movieId, "recommendations", movieRecommendationsUrl, func(movies *RelatedMovies, list []MovieMatch) {
+			movies.Recommendations = list
+		})
+		semaphore <- struct{}{}
+		go read(movieId, "similar", movieSimilarUrl, func(movies *RelatedMovies, list []MovieMatch) {
+			movies.Similar = list
+		})
+	}
+	wg.Wait()
+
+	return related
+}
+
+// DiscoverMovies returns popular films with any of the genres, as TMDB names them, or of any genre without them
+func DiscoverMovies(genres []string) ([]MovieMatch, error) {
+	ids, err := getGenreIds()
+	if err != nil {
+		return nil, err
+	}
+
+	var withGenres []string
+	for _, genre := range genres {
+		if id, ok := ids[strings.ToLower(genre)]; ok {
+			withGenres = append(withGenres, fmt.Sprint(id))
+		}
+	}
+	// the same genres in another order are the same list
+	slices.Sort(withGenres)
+	joined := strings.Join(withGenres, "|")
+
+	return getMovieList(listCacheKey("discover", joined), strings.ReplaceAll(movieDiscoverUrl, "{genres}", url.QueryEscape(joined)))
+}
+
+// getMovieList reads the list from the cache, or from TMDB and caches it
+func getMovieList(key string, requestUrl string) ([]MovieMatch, error) {
+	if movies, ok := getCachedMovieList(key); ok {
+		return movies, nil
+	}
+
+	var response searchForMovieResponse
+	err := getJson(requestUrl, &response)
+	if err != nil {
+		return nil, err
+	}
+
+	movies := make([]MovieMatch, 0, len(response.Results))
+	for _, movie := range response.Results {
+		movies = append(movies, toMovieMatch(movie.ID, movie.Title, movie.ReleaseDate))
+	}
+
+	cacheMovieList(key, movies)
+	return movies, nil
+}
+
+// getGenreIds maps lowercased genre names to their ids, the list is read once per lambda instance
+func getGenreIds() (map[string]int, error) {
+	genreIdsMutex.Lock()
+	defer genreIdsMutex.Unlock()
+	if genreIds != nil {
+		return genreIds, nil
+	}
+
+	var response genreListResponse
+	err := getJson(genreListUrl, &response)
+	if err != nil {
+		return nil, err
+	}
+
+	genreIds = make(map[string]int, len(response.Genres))
+	for _, genre := range response.Genres {
+		genreIds[strings.ToLower(genre.Name)] = genre.ID
+	}
+	return genreIds, nil
+}
+
+type genreListResponse struct {
+	Genres []Genre `json:"genres"`
+}
@@ -0,0 +1,503 @@
+package tmdb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var movieSearchUrl = "https://api.themoviedb.org/3/search/movie?query={query}&include_adult=true&page=1&language=en-US&year={year}"
+var movieFindUrl = "https://api.themoviedb.org/3/find/{external_id}?external_source=imdb_id"
+var movieImageUrl = "https://api.themoviedb.org/3/movie/{movieId}/images"
+var movieDirectorSearchUrl = "https://api.themoviedb.org/3/movie/{movie_id}/credits"
+var movieDetailsSearchUrl = "https://api.themoviedb.org/3/movie/{movie_id}"
+var imagePrefix = "https://image.tmdb.org/t/p/w500"
+
+var tmdbToken = os.Getenv("TMDBReadToken")
+
+var maxConcurrentLookups = 8
+
+func NormalizeFilms(recommendedFilms []FilmTitle) ([]ResultRecommendedFilm, error) {
+	normalizedFilms := make([]ResultRecommendedFilm, 0, len(recommendedFilms))
+
+	for _, recommendedFilm := range recommendedFilms {
+		film, err := recommendedFilm.details()
+		if err != nil {
+			return nil, err
+		}
+
+		normalizedFilms = append(normalizedFilms, film)
+	}
+
+	return normalizedFilms, nil
+}
+
+// NormalizeFilmsConcurrently looks up several films at once and calls found for every film as soon as
+// it is looked up, in the order lookups complete. Calls of found are never concurrent.
+func NormalizeFilmsConcurrently(recommendedFilms []FilmTitle, found func(index int, film ResultRecommendedFilm, err error)) {
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	semaphore := make(chan struct{}, maxConcurrentLookups)
+
+	for index, recommendedFilm := range recommendedFilms {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(index int, recommendedFilm FilmTitle) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			film, err := recommendedFilm.details()
+
+			mutex.Lock()
+			found(index, film, err)
+			mutex.Unlock()
+		}(index, recommendedFilm)
+	}
+	wg.Wait()
+}
+
+// GetFilmDetails finds the film in TMDB by its name, using the cache when the film was already looked up
+func GetFilmDetails(filmName string) (ResultRecommendedFilm, error) {
+	return GetFilmDetailsForYear(filmName, "")
+}
+
+// GetFilmDetailsForYear finds the film by its name, preferring the given release year if there is one
+func GetFilmDetailsForYear(filmName string, year string) (ResultRecommendedFilm, error) {
+	key := cacheKey(filmName, year)
+	if film, ok := getCachedFilm(key); ok {
+		return film, nil
+	}
+
+	film, err := fetchFilmDetails(filmName, year)
+	if err != nil {
+		return ResultRecommendedFilm{}, err
+	}
+
+	cacheFilm(key, film)
+	return film, nil
+}
+
+// GetFilmDetailsById gets the film by its TMDB id, the name of the film is its title in TMDB
+func GetFilmDetailsById(movieId int) (ResultRecommendedFilm, error) {
+	key := idCacheKey(movieId)
+	if film, ok := getCachedFilm(key); ok {
+		return film, nil
+	}
+
+	film, err := fetchMovieDetails(movieId, "")
+	if err != nil {
+		return ResultRecommendedFilm{}, err
+	}
+
+	cacheFilm(key, film)
+	return film, nil
+}
+
+// GetFilmsDetails looks up several films at once. Films which are not found are logged and left out of the result
+func GetFilmsDetails(filmNames []string) map[string]ResultRecommendedFilm {
+	films := make(map[string]ResultRecommendedFilm, len(filmNames))
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	semaphore := make(chan struct{}, maxConcurrentLookups)
+
+	for _, filmName := range filmNames {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(filmName string) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			film, err := GetFilmDetails(filmName)
+			if err != nil {
+				log.Printf("Got error getting film details, film - %s, error - %v", filmName, err)
+				return
+			}
+
+			mutex.Lock()
+			films[filmName] = film
+			mutex.Unlock()
+		}(filmName)
+	}
+	wg.Wait()
+
+	return films
+}
+
+func fetchFilmDetails(filmName string, year string) (ResultRecommendedFilm, error) {
+	movie, err := FindMovie(filmName, year)
+	if err != nil {
+		return ResultRecommendedFilm{}, err
+	}
+
+	return fetchMovieDetails(movie.ID, filmName)
+}
+
+// fetchMovieDetails gets details, directors and images of the film, named by its title in TMDB when filmName is empty
+func fetchMovieDetails(movieId int, filmName string) (ResultRecommendedFilm, error) {
+	movieDetails, err := searchForMovieDetails(movieId)
+	if err != nil {
+		return ResultRecommendedFilm{}, err
+	}
+	if movieDetails.ID == 0 {
+		return ResultRecommendedFilm{}, fmt.Errorf("film with TMDB id %d not found", movieId)
+	}
+	if filmName == "" {
+		filmName = movieDetails.Title
+	}
+
+	directors, err := searchForDirector(movieId)
+	if err != nil {
+		return ResultRecommendedFilm{}, err
+	}
+
+	images, err := getImages(movieId)
+	if err != nil {
+		return ResultRecommendedFilm{}, err
+	}
+
+	return constructFilm(movieDetails, filmName, directors, images), nil
+}
+
+func searchForMovieDetails(movieId int) (Movie, error) {
+	req, _ := http.NewRequest("GET", strings.ReplaceAll(movieDetailsSearchUrl, "{movie_id}", fmt.Sprint(movieId)), nil)
+	req.Header.Add("accept", "application/json")
+	req.Header.Add("Authorization", "Bearer "+tmdbToken)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return Movie{}, err
+	}
+
+	defer res.Body.Close()
+	byteResponse, err := io.ReadAll(res.Body)
+	if err != nil {
+		return Movie{}, err
+	}
+
+	var movie Movie
+	err = json.Unmarshal(byteResponse, &movie)
+	if err != nil {
+		return Movie{}, err
+	}
+
+	return movie, nil
+}
+
+// FindMovie finds the most popular film with the name, preferring the given release year if there is one
+func FindMovie(filmName string, year string) (MovieMatch, error) {
+	movie, err := searchForMovie(filmName, year)
+	if err != nil && year != "" {
+		movie, err = searchForMovie(filmName, "")
+	}
+	if err != nil {
+		return MovieMatch{}, err
+	}
+
+	return toMovieMatch(movie.ID, movie.Title, movie.ReleaseDate), nil
+}
+
+// FindMovieByImdbId finds the film by its IMDb id, like 'tt0113277'
+func FindMovieByImdbId(imdbId string) (MovieMatch, error) {
+	var response findMovieResponse
+	err := getJson(strings.ReplaceAll(movieFindUrl, "{external_id}", url.PathEscape(imdbId)), &response)
+	if err != nil {
+		return MovieMatch{}, err
+	}
+	if len(response.MovieResults) == 0 {
+		return MovieMatch{}, fmt.Errorf("film with IMDb id %s not found", imdbId)
+	}
+
+	movie := response.MovieResults[0]
+	return toMovieMatch(movie.ID, movie.Title, movie.ReleaseDate), nil
+}
+
+// FindMovieById gets the film by its TMDB id
+func FindMovieById(movieId int) (MovieMatch, error) {
+	movie, err := searchForMovieDetails(movieId)
+	if err != nil {
+		return MovieMatch{}, err
+	}
+	if movie.ID == 0 {
+		return MovieMatch{}, fmt.Errorf("film with TMDB id %d not found", movieId)
+	}
+
+	return toMovieMatch(movie.ID, movie.Title, movie.ReleaseDate), nil
+}
+
+func toMovieMatch(movieId int, title string, releaseDate string) MovieMatch {
+	var year string
+	if len(releaseDate) >= 4 {
+		year = releaseDate[0:4]
+	}
+
+	return MovieMatch{ID: movieId, Title: title, Year: year}
+}
+
+func searchForMovie(recommendedFilm string, year string) (movieIdResponse, error) {
+	searchUrl := strings.ReplaceAll(strings.ReplaceAll(movieSearchUrl, "{query}", url.QueryEscape(recommendedFilm)), "{year}", year)
+	req, _ := http.NewRequest("GET", searchUrl, nil)
+	req.Header.Add("accept", "application/json")
+	req.Header.Add("Authorization", "Bearer "+tmdbToken)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return movieIdResponse{}, err
+	}
+
+	defer res.Body.Close()
+	byteResponse, err := io.ReadAll(res.Body)
+	if err != nil {
+		return movieIdResponse{}, err
+	}
+
+	var response searchForMovieResponse
+	err = json.Unmarshal(byteResponse, &response)
+	if err != nil {
+		return movieIdResponse{}, err
+	}
+	if len(response.Results) == 0 {
+		return movieIdResponse{}, errors.New(fmt.Sprintf("Response results is empty. Recommended film - %s. Response results - %v", recommendedFilm, response.Results))
+	}
+
+	filteredFilms := filterResponseResultByName(response.Results, response.Results[0].Title)
+	sort.Slice(filteredFilms, func(i, j int) bool {
+		return filteredFilms[i].Popularity > filteredFilms[j].Popularity
+	})
+
+	return filteredFilms[0], nil
+}
+
+func getJson(requestUrl string, v any) error {
+	req, _ := http.NewRequest("GET", requestUrl, nil)
+	req.Header.Add("accept", "application/json")
+	req.Header.Add("Authorization", "Bearer "+tmdbToken)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer res.Body.Close()
+	byteResponse, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("TMDB responded with status %d, body - %s", res.StatusCode, string(byteResponse))
+	}
+
+	return json.Unmarshal(byteResponse, v)
+}
+
+func filterResponseResultByName(results []movieIdResponse, filmName string) []movieIdResponse {
+	var filteredFilms []movieIdResponse
+	for _, movie := range results {
+		if movie.Title == filmName {
+			filteredFilms = append(filteredFilms, movie)
+		}
+	}
+
+	return filteredFilms
+}
+
+func searchForDirector(movieId int) ([]string, error) {
+	req, _ := http.NewRequest("GET", strings.ReplaceAll(movieDirectorSearchUrl, "{movie_id}", fmt.Sprint(movieId)), nil)
+	req.Header.Add("accept", "application/json")
+	req.Header.Add("Authorization", "Bearer "+tmdbToken)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return make([]string, 0), err
+	}
+
+	defer res.Body.Close()
+	byteResponse, err := io.ReadAll(res.Body)
+	if err != nil {
+		return make([]string, 0), err
+	}
+
+	var response MovieDetail
+	err = json.Unmarshal(byteResponse, &response)
+	if err != nil {
+		return make([]string, 0), err
+	}
+
+	directors := make([]string, 0)
+	for _, director := range response.Crew {
+		if director.Job == "Director" {
+			directors = append(directors, director.Name)
+		}
+	}
+
+	if len(directors) == 0 {
+		return make([]string, 0), errors.New("Film director not found. Response crew - " + string(byteResponse))
+	} else {
+		return directors, nil
+	}
+}
+
+func getImages(movieId int) (MovieImages, error) {
+	req, _ := http.NewRequest("GET", strings.ReplaceAll(movieImageUrl, "{movieId}", fmt.Sprint(movieId)), nil)
+	req.Header.Add("accept", "application/json")
+	req.Header.Add("Authorization", "Bearer "+tmdbToken)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return MovieImages{}, err
+	}
+
+	defer res.Body.Close()
+	byteResponse, err := io.ReadAll(res.Body)
+	if err != nil {
+		return MovieImages{}, err
+	}
+
+	var response MovieImages
+	err = json.Unmarshal(byteResponse, &response)
+	if err != nil {
+		return MovieImages{}, err
+	}
+
+	for index, image := range response.Posters {
+		if index == 4 {
+			response.Posters = response.Posters[0:4]
+			break
+		}
+
+		image.FilePath = imagePrefix + image.FilePath
+		response.Posters[index] = image
+	}
+	for index, image := range response.Backdrops {
+		if index == 4 {
+			response.Backdrops = response.Backdrops[0:4]
+			break
+		}
+
+		image.FilePath = imagePrefix + image.FilePath
+		response.Backdrops[index] = image
+	}
+
+	return response, nil
+}
+
+func constructFilm(movieDetails Movie, recommendedFilm string, directors []string, images MovieImages) ResultRecommendedFilm {
+	var genreNames []string
+
+	for _, genre := range movieDetails.Genres {
+		genreNames = append(genreNames, genre.Name)
+	}
+
+	var year string
+	if len(movieDetails.ReleaseDate) >= 4 {
+		year = movieDetails.ReleaseDate[0:4]
+	}
+
+	return ResultRecommendedFilm{
+		ID:               movieDetails.ID,
+		Name:             recommendedFilm,
+		Year:             year,
+		Genres:           genreNames,
+		DirectedBy:       directors,
+		Description:      movieDetails.Overview,
+		OriginalLanguage: movieDetails.OriginalLanguage,
+		Runtime:          movieDetails.Runtime,
+		VoteAverage:      movieDetails.VoteAverage,
+		Adult:            movieDetails.Adult,
+		MovieImages:      images,
+	}
+}
+
+type movieIdResponse struct {
+	ID          int     `json:"id"`
+	Title       string  `json:"title"`
+	ReleaseDate string  `json:"release_date"`
+	Popularity  float64 `json:"popularity"`
+}
+
+type searchForMovieResponse struct {
+	Results []movieIdResponse `json:"results"`
+}
+
+type findMovieResponse struct {
+	MovieResults []movieIdResponse `json:"movie_results"`
+}
+
+// FilmTitle is a film name with an optional release year, which tells apart films with the same name.
+// A film with a TMDB id is got by the id, the name and the year are not searched then.
+type FilmTitle struct {
+	ID   int
+	Name string
+	Year string
+}
+
+func (f FilmTitle) details() (ResultRecommendedFilm, error) {
+	if f.ID != 0 {
+		return GetFilmDetailsById(f.ID)
+	}
+
+	return GetFilmDetailsForYear(f.Name, f.Year)
+}
+
+type MovieMatch struct {
+	ID    int    `json:"tmdbId"`
+	Title string `json:"title"`
+	Year  string `json:"year"`
+}
+
+type Movie struct {
+	Adult            bool    `json:"adult"`
+	Genres           []Genre `json:"genres"`
+	ID               int     `json:"id"`
+	OriginalLanguage string  `json:"original_language"`
+	OriginalTitle    string  `json:"original_title"`
+	Overview         string  `json:"overview"`
+	ReleaseDate      string  `json:"release_date"`
+	Runtime          int     `json:"runtime"`
+	Title            string  `json:"title"`
+	VoteAverage      float64 `json:"vote_average"`
+}
+
+type Genre struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type ResultRecommendedFilm struct {
+	ID               int         `json:"tmdbId"`
+	Name             string      `json:"name"`
+	Year             string      `json:"year"`
+	Genres           []string    `json:"genres"`
+	DirectedBy       []string    `json:"directedBy"`
+	Description      string      `json:"description"`
+	OriginalLanguage string      `json:"originalLanguage"`
+	Runtime          int         `json:"runtime"`
+	VoteAverage      float64     `json:"voteAverage"`
+	Adult            bool        `json:"adult"`
+	MovieImages      MovieImages `json:"movieImages"`
+}
+
+type MovieDetail struct {
+	Crew []Person `json:"crew"`
+}
+
+type Person struct {
+	Name string `json:"name"`
+	Job  string `json:"job"`
+}
+
+type Image struct {
+	FilePath string `json:"file_path"`
+}
+
+type MovieImages struct {
+	Backdrops []Image `json:"backdrops"`
+	Posters   []Image `json:"posters"`
+}
+
+type FilmRecommendation struct {
+	FilmName string `json:"filmName"`
+	Year     string `json:"year"`
+}
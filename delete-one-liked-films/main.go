@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"finder/common/idempotency"
+	"finder/common/profile"
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go/aws"
@@ -15,18 +17,18 @@ var sess = session.Must(session.NewSession())
 var db = dynamodb.New(sess)
 
 func main() {
-	lambda.Start(handleRequest)
+	lambda.Start(idempotency.Wrap("delete-one-liked-films", handleRequest))
 }
 
 func handleRequest(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 	userId, err := getUserIdAndVerify(req)
 	if err != nil {
 		id := req.QueryStringParameters["id"]
-		log.Fatalf("Provided user id is not correct, user id - %s", id)
+		log.Printf("Provided user id is not correct, user id - %s", id)
 		return events.APIGatewayProxyResponse{
 			StatusCode: 400,
 			Body:       "Provided user id is not correct, user id - " + id,
-		}, err
+		}, nil
 	}
 
 	filmToRemove := req.QueryStringParameters["filmToRemove"]
@@ -41,35 +43,35 @@ func handleRequest(ctx context.Context, req events.APIGatewayProxyRequest) (even
 		},
 	})
 	if err != nil {
-		log.Fatalf("Got error calling GetItem: %s", err)
+		log.Printf("Got error calling GetItem: %s", err)
 		return events.APIGatewayProxyResponse{
 			StatusCode: 500,
 			Body:       "Got error calling GetItem: " + err.Error(),
-		}, err
+		}, nil
 	}
 
 	if result.Item == nil {
-		log.Fatalf("Got error calling GetItem, user with id %s not found", userId)
+		log.Printf("Got error calling GetItem, user with id %s not found", userId)
 		return events.APIGatewayProxyResponse{
-			StatusCode: 500,
+			StatusCode: 404,
 			Body:       "Got error calling GetItem, user not found.",
-		}, err
+		}, nil
 	}
 
 	//remove film from likedFilms and resave others
 	oldLikedFilms := result.Item["likedFilms"].L
 	filmToRemoveIndex := -1
 	for index, film := range oldLikedFilms {
-		if *film.S == filmToRemove {
+		if filmTitle(film) == filmToRemove {
 			filmToRemoveIndex = index
 		}
 	}
 	if filmToRemoveIndex == -1 {
-		log.Fatalf("Got error removing film - %s. Film not found", filmToRemove)
+		log.Printf("Got error removing film - %s. Film not found", filmToRemove)
 		return events.APIGatewayProxyResponse{
-			StatusCode: 500,
-			Body:       "Got error removing film - " + ". Film not found.",
-		}, err
+			StatusCode: 404,
+			Body:       "Got error removing film - " + filmToRemove + ". Film not found.",
+		}, nil
 	}
 	resultLikedFilms := append(oldLikedFilms[:filmToRemoveIndex], oldLikedFilms[filmToRemoveIndex+1:]...)
 
@@ -93,12 +95,13 @@ func handleRequest(ctx context.Context, req events.APIGatewayProxyRequest) (even
 		ReturnValues: aws.String("UPDATED_NEW"),
 	})
 	if err != nil {
-		log.Fatalf("Got error calling PutItem: %s", err)
+		log.Printf("Got error calling PutItem: %s", err)
 		return events.APIGatewayProxyResponse{
 			StatusCode: 500,
 			Body:       "Got error calling PutItem: " + err.Error(),
-		}, err
+		}, nil
 	}
+	profile.PublishChanged(userId, profile.Deleted, []string{filmToRemove})
 
 	return events.APIGatewayProxyResponse{
 		StatusCode: 204,
@@ -110,3 +113,15 @@ func getUserIdAndVerify(req events.APIGatewayProxyRequest) (string, error) {
 
 	return userId.String(), err
 }
+
+// filmTitle returns the title of a liked/unliked list entry, either a legacy plain string or a map with 'title'
+func filmTitle(film *dynamodb.AttributeValue) string {
+	if film.S != nil {
+		return *film.S
+	}
+	if title, ok := film.M["title"]; ok && title.S != nil {
+		return *title.S
+	}
+
+	return ""
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"finder/common/tmdb"
+	"fmt"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/google/uuid"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var sess = session.Must(session.NewSession())
+var db = dynamodb.New(sess)
+
+// films are looked up in TMDB by chunks, so that the first films are written out before the whole list is looked up
+var lookupChunkSize = 50
+
+// exportedLists are the user_films attributes with rated and recommended films and their names in the export.
+// These are all lists of user_films, there is no watchlist.
+var exportedLists = []struct {
+	Attribute string
+	Name      string
+}{
+	{"likedFilms", "liked"},
+	{"unlikedFilms", "unliked"},
+	{"recommendedFilms", "history"},
+}
+
+// The lambda is served by a Function URL in RESPONSE_STREAM mode, so that big profiles are written out
+// while they are read instead of being buffered. It has to be built with '-tags lambda.norpc'.
+func main() {
+	lambda.Start(handleRequest)
+}
+
+func handleRequest(ctx context.Context, req events.LambdaFunctionURLRequest) (*events.LambdaFunctionURLStreamingResponse, error) {
+	userId, err := uuid.Parse(req.QueryStringParameters["id"])
+	if err != nil {
+		id := req.QueryStringParameters["id"]
+		log.Printf("Provided user id is not correct, user id - %s", id)
+		return &events.LambdaFunctionURLStreamingResponse{
+			StatusCode: 400,
+			Body:       strings.NewReader("Provided user id is not correct, user id - " + id),
+		}, nil
+	}
+
+	format := req.QueryStringParameters["format"]
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "csv" {
+		log.Printf("Export format is not correct, format - %s", format)
+		return &events.LambdaFunctionURLStreamingResponse{
+			StatusCode: 400,
+			Body:       strings.NewReader("Export format is not correct, expected 'json' or 'csv', format - " + format),
+		}, nil
+	}
+
+	result, err := db.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String("user_films"),
+		Key: map[string]*dynamodb.AttributeValue{
+			"id": {
+				S: aws.String(userId.String()),
+			},
+		},
+	})
+	if err != nil {
+		log.Printf("Got error calling GetItem: %s", err)
+		return &events.LambdaFunctionURLStreamingResponse{
+			StatusCode: 500,
+			Body:       strings.NewReader("Got error calling GetItem: " + err.Error()),
+		}, nil
+	}
+
+	reader, writer := io.Pipe()
+	go func() {
+		var err error
+		if format == "csv" {
+			err = writeCsv(writer, result.Item)
+		} else {
+			err = writeJson(writer, userId.String(), result.Item)
+		}
+		if err != nil {
+			log.Printf("Got error writing export, user id - %s, error - %v", userId, err)
+		}
+		writer.CloseWithError(err)
+	}()
+
+	contentType := "application/json"
+	fileName := "finder-films.json"
+	if format == "csv" {
+		contentType = "text/csv"
+		fileName = "finder-films.csv"
+	}
+	return &events.LambdaFunctionURLStreamingResponse{
+		StatusCode: 200,
+		Headers: map[string]string{
+			"Content-Type":        contentType,
+			"Content-Disposition": "attachment; filename=\"" + fileName + "\"",
+		},
+		Body: reader,
+	}, nil
+}
+
+// ExportedFilm is a rated or, for 'history', a recommended film. RatedAt is then the time of the recommendation.
+type ExportedFilm struct {
+	List          string     `json:"list"`
+	Title         string     `json:"title"`
+	Year          string     `json:"year,omitempty"`
+	TmdbId        int        `json:"tmdbId,omitempty"`
+	RatedAt       *time.Time `json:"ratedAt,omitempty"`
+	Rating        float64    `json:"rating,omitempty"`
+	PromptVersion string     `json:"promptVersion,omitempty"`
+}
+
+// writeJson writes '{"id": ..., "exportedAt": ..., "films": [...]}' film by film
+func writeJson(writer io.Writer, userId string, item map[string]*dynamodb.AttributeValue) error {
+	_, err := fmt.Fprintf(writer, `{"id":%q,"exportedAt":%q,"films":[`, userId, time.Now().UTC().Format(time.RFC3339))
+	if err != nil {
+		return err
+	}
+
+	first := true
+	err = forEachFilm(item, func(film ExportedFilm) error {
+		bytes, err := json.Marshal(film)
+		if err != nil {
+			return err
+		}
+		if !first {
+			bytes = append([]byte(","), bytes...)
+		}
+		first = false
+
+		_, err = writer.Write(bytes)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(writer, "]}")
+	return err
+}
+
+// writeCsv writes the columns Letterboxd import understands. Letterboxd has no dislikes,
+// so the list a film comes from is kept in 'Tags'. Recommended films are not watched, they are left out.
+func writeCsv(writer io.Writer, item map[string]*dynamodb.AttributeValue) error {
+	csvWriter := csv.NewWriter(writer)
+	err := csvWriter.Write([]string{"tmdbID", "Title", "Year", "Rating10", "WatchedDate", "Tags"})
+	if err != nil {
+		return err
+	}
+
+	err = forEachFilm(item, func(film ExportedFilm) error {
+		if film.List == "history" {
+			return nil
+		}
+
+		record := []string{"", film.Title, film.Year, "", "", "finder-" + film.List}
+		if film.TmdbId != 0 {
+			record[0] = strconv.Itoa(film.TmdbId)
+		}
+		if film.Rating != 0 {
+			record[3] = strconv.FormatFloat(film.Rating, 'f', -1, 64)
+		}
+		if film.RatedAt != nil {
+			record[4] = film.RatedAt.Format(time.DateOnly)
+		}
+
+		err := csvWriter.Write(record)
+		csvWriter.Flush()
+		if err != nil {
+			return err
+		}
+		return csvWriter.Error()
+	})
+	if err != nil {
+		return err
+	}
+
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
+
+// forEachFilm goes through all exported lists, chunk by chunk
+func forEachFilm(item map[string]*dynamodb.AttributeValue, write func(film ExportedFilm) error) error {
+	for _, list := range exportedLists {
+		if item[list.Attribute] == nil {
+			continue
+		}
+
+		entries := item[list.Attribute].L
+		for start := 0; start < len(entries); start += lookupChunkSize {
+			films := make([]ExportedFilm, 0, lookupChunkSize)
+			for _, entry := range entries[start:min(start+lookupChunkSize, len(entries))] {
+				if film, ok := toExportedFilm(list.Name, entry); ok {
+					films = append(films, film)
+				}
+			}
+			lookUpFilms(films)
+
+			for _, film := range films {
+				err := write(film)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// lookUpFilms looks up films stored without TMDB id at once and fills their id and year. Films stored with
+// an id are exported as they are, films which are not found are exported by name only.
+func lookUpFilms(films []ExportedFilm) {
+	var titles []tmdb.FilmTitle
+	var indexes []int
+	for index, film := range films {
+		if film.TmdbId == 0 {
+			titles = append(titles, tmdb.FilmTitle{Name: film.Title})
+			indexes = append(indexes, index)
+		}
+	}
+
+	tmdb.NormalizeFilmsConcurrently(titles, func(index int, details tmdb.ResultRecommendedFilm, err error) {
+		if err != nil {
+			return
+		}
+		films[indexes[index]].TmdbId = details.ID
+		films[indexes[index]].Year = details.Year
+	})
+}
+
+func toExportedFilm(list string, entry *dynamodb.AttributeValue) (ExportedFilm, bool) {
+	if entry.S != nil {
+		return ExportedFilm{List: list, Title: *entry.S}, true
+	}
+
+	title, ok := entry.M["title"]
+	if !ok || title.S == nil {
+		return ExportedFilm{}, false
+	}
+
+	film := ExportedFilm{List: list, Title: *title.S}
+	ratedAt, ok := entry.M["ratedAt"]
+	if !ok {
+		ratedAt, ok = entry.M["recommendedAt"]
+	}
+	if ok && ratedAt.N != nil {
+		seconds, err := strconv.ParseInt(*ratedAt.N, 10, 64)
+		if err == nil {
+			timestamp := time.Unix(seconds, 0).UTC()
+			film.RatedAt = &timestamp
+		}
+	}
+	if tmdbId, ok := entry.M["tmdbId"]; ok && tmdbId.N != nil {
+		film.TmdbId, _ = strconv.Atoi(*tmdbId.N)
+	}
+	if rating, ok := entry.M["rating"]; ok && rating.N != nil {
+		film.Rating, _ = strconv.ParseFloat(*rating.N, 64)
+	}
+	if promptVersion, ok := entry.M["promptVersion"]; ok && promptVersion.S != nil {
+		film.PromptVersion = *promptVersion.S
+	}
+
+	return film, true
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Films of a session are recommended by get-films for the participants as a group and stored in
+// 'film_session_films' by position, so that every participant swipes through the same films in the same order.
+// get-films is called at 'GetFilmsUrl' environment variable when a participant reaches the end of the stored films.
+var sessionFilmsTable = "film_session_films"
+var sessionPageSize = 10
+var maxSessionFilms = 100
+
+var getFilmsTimeout = 60 * time.Second
+
+type SessionFilm struct {
+	Position int `json:"position"`
+	// Film is the film as get-films returned it
+	Film json.RawMessage `json:"film"`
+}
+
+type FilmsPage struct {
+	Films      []SessionFilm `json:"films"`
+	NextCursor int           `json:"nextCursor"`
+}
+
+// getFilmsPage returns films from the cursor on, more films are recommended first when there are not enough of them
+func getFilmsPage(ctx context.Context, filmSession *Session, cursor int) (FilmsPage, error) {
+	if cursor <= filmSession.FilmCount && cursor+sessionPageSize > filmSession.FilmCount && filmSession.FilmCount < maxSessionFilms {
+		films, err := requestFilms(ctx, filmSession.Participants)
+		if err != nil {
+			return FilmsPage{}, err
+		}
+
+		err = appendFilms(filmSession, films)
+		if err != nil {
+			return FilmsPage{}, err
+		}
+	}
+
+	films, err := queryFilms(filmSession.Code, cursor, sessionPageSize)
+	if err != nil {
+		return FilmsPage{}, err
+	}
+
+	page := FilmsPage{
+		Films:      films,
+		NextCursor: cursor,
+	}
+	if len(films) > 0 {
+		page.NextCursor = films[len(films)-1].Position + 1
+	}
+	return page, nil
+}
+
+// requestFilms asks get-films for films for the participants, it excludes films already recommended to any of them
+func requestFilms(ctx context.Context, participants []string) ([]json.RawMessage, error) {
+	getFilmsUrl := os.Getenv("GetFilmsUrl")
+	if getFilmsUrl == "" {
+		return nil, errors.New("GetFilmsUrl environment variable is not set")
+	}
+
+	query := url.Values{}
+	for _, participant := range participants {
+		query.Add("id", participant)
+	}
+	query.Set("filmCount", strconv.Itoa(sessionPageSize))
+
+	ctx, cancel := context.WithTimeout(ctx, getFilmsTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", getFilmsUrl+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("got error calling get-films: %w", err)
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("get-films responded with %d: %s", res.StatusCode, body)
+	}
+
+	var films []json.RawMessage
+	err = json.Unmarshal(body, &films)
+	if err != nil {
+		return nil, fmt.Errorf("got error parsing films of get-films: %w", err)
+	}
+
+	return films, nil
+}
+
+// appendFilms stores the films after the films of the session in one transaction with the film count.
+// When another participant appended films meanwhile, these films are dropped and the stored ones are used.
+func appendFilms(filmSession *Session, films []json.RawMessage) error {
+	films = films[:min(len(films), maxSessionFilms-filmSession.FilmCount)]
+	if len(films) == 0 {
+		return nil
+	}
+
+	expiresAt := aws.String(strconv.FormatInt(filmSession.ExpiresAt, 10))
+	items := []*dynamodb.TransactWriteItem{
+		{
+			Update: &dynamodb.Update{
+				TableName: aws.String(sessionsTable),
+				Key: map[string]*dynamodb.AttributeValue{
+					"code": {
+						S: aws.String(filmSession.Code),
+					},
+				},
+				ConditionExpression: aws.String("filmCount = :oldFilmCount"),
+				UpdateExpression:    aws.String("SET filmCount = :filmCount"),
+				ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+					":oldFilmCount": {
+						N: aws.String(strconv.Itoa(filmSession.FilmCount)),
+					},
+					":filmCount": {
+						N: aws.String(strconv.Itoa(filmSession.FilmCount + len(films))),
+					},
+				},
+			},
+		},
+	}
+	for index, film := range films {
+		items = append(items, &dynamodb.TransactWriteItem{
+			Put: &dynamodb.Put{
+				TableName: aws.String(sessionFilmsTable),
+				Item: map[string]*dynamodb.AttributeValue{
+					"code": {
+						S: aws.String(filmSession.Code),
+					},
+					"position": {
+						N: aws.String(strconv.Itoa(filmSession.FilmCount + index)),
+					},
+					"film": {
+						S: aws.String(string(film)),
+					},
+					"expiresAt": {
+						N: expiresAt,
+					},
+				},
+			},
+		})
+	}
+
+	_, err := db.TransactWriteItems(&dynamodb.TransactWriteItemsInput{
+		TransactItems: items,
+	})
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) && awsErr.Code() == dynamodb.ErrCodeTransactionCanceledException {
+		log.Printf("Films of session %s were appended concurrently, %d films are dropped", filmSession.Code, len(films))
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("got error calling TransactWriteItems: %w", err)
+	}
+
+	filmSession.FilmCount += len(films)
+	return nil
+}
+
+// queryFilms returns at most limit films from the position on, all films with a limit of 0
+func queryFilms(code string, from int, limit int) ([]SessionFilm, error) {
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(sessionFilmsTable),
+		KeyConditionExpression: aws.String("code = :code AND #position >= :from"),
+		ExpressionAttributeNames: map[string]*string{
+			"#position": aws.String("position"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":code": {
+				S: aws.String(code),
+			},
+			":from": {
+				N: aws.String(strconv.Itoa(from)),
+			},
+		},
+		ConsistentRead: aws.Bool(true),
+	}
+	if limit > 0 {
+		input.Limit = aws.Int64(int64(limit))
+	}
+
+	films := make([]SessionFilm, 0)
+	err := db.QueryPages(input, func(output *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, item := range output.Items {
+			if item["film"] == nil || item["film"].S == nil {
+				continue
+			}
+			films = append(films, SessionFilm{
+				Position: parseNumber(item["position"]),
+				Film:     json.RawMessage(*item["film"].S),
+			})
+		}
+		return limit == 0
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return films, nil
+}
+
+// findMatches returns the films every participant liked. A film someone has not swiped yet is not a match.
+func findMatches(filmSession *Session) ([]SessionFilm, error) {
+	films, err := queryFilms(filmSession.Code, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	matches := make([]SessionFilm, 0)
+	for _, film := range films {
+		position := strconv.Itoa(film.Position)
+		matched := true
+		for _, participant := range filmSession.Participants {
+			if filmSession.Swipes[participant][position] != "like" {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			matches = append(matches, film)
+		}
+	}
+
+	return matches, nil
+}
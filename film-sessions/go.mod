@@ -0,0 +1,15 @@
+module finder
+
+go 1.21
+
+require (
+	github.com/aws/aws-lambda-go v1.45.0
+	github.com/aws/aws-sdk-go v1.50.5
+	github.com/google/uuid v1.6.0
+)
+
+require github.com/jmespath/go-jmespath v0.4.0 // indirect
+
+require finder/common v0.0.0
+
+replace finder/common => ../common
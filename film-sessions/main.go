@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"finder/common/idempotency"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/google/uuid"
+	"log"
+	"strconv"
+	"strings"
+)
+
+var sess = session.Must(session.NewSession())
+var db = dynamodb.New(sess)
+
+func main() {
+	lambda.Start(idempotency.Wrap("film-sessions", handleRequest))
+}
+
+// handleRequest serves every action of a session: the owner creates it and shares its code, participants join
+// with the code, swipe through the same films and get the films everybody liked
+func handleRequest(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	userId, err := getUserIdAndVerify(req)
+	if err != nil {
+		id := req.QueryStringParameters["id"]
+		log.Printf("Provided user id is not correct, user id - %s", id)
+		return events.APIGatewayProxyResponse{
+			StatusCode: 400,
+			Body:       "Provided user id is not correct, user id - " + id,
+		}, nil
+	}
+
+	action := req.QueryStringParameters["action"]
+	if action == "create" {
+		return handleCreate(userId), nil
+	}
+
+	code := strings.ToUpper(strings.TrimSpace(req.QueryStringParameters["code"]))
+	filmSession, err := getSession(code)
+	if err != nil {
+		log.Printf("Got error reading session %s: %v", code, err)
+		return events.APIGatewayProxyResponse{
+			StatusCode: 500,
+			Body:       "Got error calling GetItem: " + err.Error(),
+		}, nil
+	}
+	if filmSession == nil {
+		log.Printf("Session not found or expired, code - %s", code)
+		return events.APIGatewayProxyResponse{
+			StatusCode: 404,
+			Body:       "Session not found or expired, code - " + code,
+		}, nil
+	}
+
+	if action == "join" {
+		return handleJoin(filmSession, userId), nil
+	}
+	if !filmSession.hasParticipant(userId) {
+		log.Printf("User %s is not a participant of session %s", userId, code)
+		return events.APIGatewayProxyResponse{
+			StatusCode: 403,
+			Body:       "User is not a participant of the session, join it first, code - " + code,
+		}, nil
+	}
+
+	switch action {
+	case "films":
+		return handleFilms(ctx, filmSession, req), nil
+	case "swipe":
+		return handleSwipe(filmSession, userId, req), nil
+	case "matches":
+		return handleMatches(filmSession), nil
+	}
+
+	log.Printf("Provided action is not correct, action - %s", action)
+	return events.APIGatewayProxyResponse{
+		StatusCode: 400,
+		Body:       "Provided action is not correct, expected 'create', 'join', 'films', 'swipe' or 'matches', action - " + action,
+	}, nil
+}
+
+func handleCreate(userId string) events.APIGatewayProxyResponse {
+	filmSession, err := createSession(userId)
+	if err != nil {
+		log.Printf("Got error creating session: %v", err)
+		return events.APIGatewayProxyResponse{
+			StatusCode: 500,
+			Body:       "Got error creating session: " + err.Error(),
+		}
+	}
+
+	return toJsonResponse(201, filmSession)
+}
+
+func handleJoin(filmSession *Session, userId string) events.APIGatewayProxyResponse {
+	if filmSession.hasParticipant(userId) {
+		return toJsonResponse(200, filmSession)
+	}
+	if len(filmSession.Participants) >= maxParticipants {
+		log.Printf("Session %s is full", filmSession.Code)
+		return events.APIGatewayProxyResponse{
+			StatusCode: 409,
+			Body:       "Session is full, at most " + strconv.Itoa(maxParticipants) + " participants, code - " + filmSession.Code,
+		}
+	}
+
+	err := joinSession(filmSession.Code, userId)
+	if isConditionFailed(err) {
+		log.Printf("Session %s was filled or expired while joining", filmSession.Code)
+		return events.APIGatewayProxyResponse{
+			StatusCode: 409,
+			Body:       "Session is full or expired, code - " + filmSession.Code,
+		}
+	}
+	if err != nil {
+		log.Printf("Got error joining session %s: %v", filmSession.Code, err)
+		return events.APIGatewayProxyResponse{
+			StatusCode: 500,
+			Body:       "Got error calling UpdateItem: " + err.Error(),
+		}
+	}
+
+	filmSession.Participants = append(filmSession.Participants, userId)
+	return toJsonResponse(200, filmSession)
+}
+
+func handleFilms(ctx context.Context, filmSession *Session, req events.APIGatewayProxyRequest) events.APIGatewayProxyResponse {
+	cursor := 0
+	if param := req.QueryStringParameters["cursor"]; param != "" {
+		var err error
+		cursor, err = strconv.Atoi(param)
+		if err != nil || cursor < 0 {
+			log.Printf("Provided cursor is not correct, cursor - %s", param)
+			return events.APIGatewayProxyResponse{
+				StatusCode: 400,
+				Body:       "Provided cursor is not correct, cursor - " + param,
+			}
+		}
+	}
+
+	page, err := getFilmsPage(ctx, filmSession, cursor)
+	if err != nil {
+		log.Printf("Got error getting films of session %s: %v", filmSession.Code, err)
+		return events.APIGatewayProxyResponse{
+			StatusCode: 500,
+			Body:       "Got error getting films of the session: " + err.Error(),
+		}
+	}
+
+	return toJsonResponse(200, page)
+}
+
+func handleSwipe(filmSession *Session, userId string, req events.APIGatewayProxyRequest) events.APIGatewayProxyResponse {
+	method := req.QueryStringParameters["method"]
+	if method != "like" && method != "unlike" {
+		log.Printf("Provided method is not correct, method - %s", method)
+		return events.APIGatewayProxyResponse{
+			StatusCode: 400,
+			Body:       "Provided method is not correct, expected 'like' or 'unlike', method - " + method,
+		}
+	}
+
+	param := req.QueryStringParameters["position"]
+	position, err := strconv.Atoi(param)
+	if err != nil || position < 0 || position >= filmSession.FilmCount {
+		log.Printf("Provided position is not correct, position - %s", param)
+		return events.APIGatewayProxyResponse{
+			StatusCode: 400,
+			Body:       "Provided position is not a film of the session, position - " + param,
+		}
+	}
+
+	err = recordSwipe(filmSession.Code, userId, position, method)
+	if err != nil {
+		log.Printf("Got error recording swipe in session %s: %v", filmSession.Code, err)
+		return events.APIGatewayProxyResponse{
+			StatusCode: 500,
+			Body:       "Got error calling UpdateItem: " + err.Error(),
+		}
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 204,
+	}
+}
+
+func handleMatches(filmSession *Session) events.APIGatewayProxyResponse {
+	matches, err := findMatches(filmSession)
+	if err != nil {
+		log.Printf("Got error finding matches of session %s: %v", filmSession.Code, err)
+		return events.APIGatewayProxyResponse{
+			StatusCode: 500,
+			Body:       "Got error calling Query: " + err.Error(),
+		}
+	}
+
+	return toJsonResponse(200, map[string]any{
+		"participants": len(filmSession.Participants),
+		"films":        matches,
+	})
+}
+
+func toJsonResponse(statusCode int, value any) events.APIGatewayProxyResponse {
+	body, err := json.Marshal(value)
+	if err != nil {
+		log.Printf("Got error parsing result to JSON: %v", err)
+		return events.APIGatewayProxyResponse{
+			StatusCode: 500,
+			Body:       "Got error parsing result to JSON: " + err.Error(),
+		}
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: statusCode,
+		Body:       string(body),
+	}
+}
+
+func getUserIdAndVerify(req events.APIGatewayProxyRequest) (string, error) {
+	userId, err := uuid.Parse(req.QueryStringParameters["id"])
+
+	return userId.String(), err
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"math/big"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// Sessions are kept in 'film_sessions' by their code, with the participants and their swipes, and films of sessions
+// in 'film_session_films'. Items of both tables expire via the 'expiresAt' TTL attribute.
+var sessionsTable = "film_sessions"
+var sessionTtl = 24 * time.Hour
+
+// maxParticipants is the same as the biggest group get-films recommends for
+var maxParticipants = 8
+
+// codes are short to be typed from another phone, letters and digits which look alike are left out
+var codeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+var codeLength = 6
+
+var maxRetries = 3
+
+type Session struct {
+	Code         string   `json:"code"`
+	OwnerId      string   `json:"ownerId"`
+	Participants []string `json:"participants"`
+	FilmCount    int      `json:"filmCount"`
+	ExpiresAt    int64    `json:"expiresAt"`
+	// Swipes are 'like' or 'unlike' by the position of the film, for every participant
+	Swipes map[string]map[string]string `json:"-"`
+}
+
+func (s *Session) hasParticipant(userId string) bool {
+	return slices.Contains(s.Participants, userId)
+}
+
+// createSession stores a new session with a random code, another code is tried if the code is taken
+func createSession(ownerId string) (*Session, error) {
+	now := time.Now()
+	for attempts := 0; attempts < maxRetries; attempts++ {
+		code, err := generateCode()
+		if err != nil {
+			return nil, err
+		}
+
+		filmSession := &Session{
+			Code:         code,
+			OwnerId:      ownerId,
+			Participants: []string{ownerId},
+			ExpiresAt:    now.Add(sessionTtl).Unix(),
+		}
+		_, err = db.PutItem(&dynamodb.PutItemInput{
+			TableName: aws.String(sessionsTable),
+			Item: map[string]*dynamodb.AttributeValue{
+				"code": {
+					S: aws.String(code),
+				},
+				"ownerId": {
+					S: aws.String(ownerId),
+				},
+				"participants": {
+					L: []*dynamodb.AttributeValue{{S: aws.String(ownerId)}},
+				},
+				"swipes": {
+					M: map[string]*dynamodb.AttributeValue{
+						ownerId: {M: map[string]*dynamodb.AttributeValue{}},
+					},
+				},
+				"filmCount": {
+					N: aws.String("0"),
+				},
+				"createdAt": {
+					N: aws.String(strconv.FormatInt(now.Unix(), 10)),
+				},
+				"expiresAt": {
+					N: aws.String(strconv.FormatInt(filmSession.ExpiresAt, 10)),
+				},
+			},
+			ConditionExpression: aws.String("attribute_not_exists(code)"),
+		})
+		if err == nil {
+			return filmSession, nil
+		}
+		if !isConditionFailed(err) {
+			return nil, fmt.Errorf("got error calling PutItem: %w", err)
+		}
+	}
+
+	return nil, fmt.Errorf("no free session code found in %d attempts", maxRetries)
+}
+
+func generateCode() (string, error) {
+	code := make([]byte, codeLength)
+	for i := range code {
+		index, err := rand.Int(rand.Reader, big.NewInt(int64(len(codeAlphabet))))
+		if err != nil {
+			return "", err
+		}
+		code[i] = codeAlphabet[index.Int64()]
+	}
+
+	return string(code), nil
+}
+
+// getSession returns nil when there is no session with the code. Expired sessions count as missing,
+// as DynamoDB deletes expired items only some time later.
+func getSession(code string) (*Session, error) {
+	if code == "" {
+		return nil, nil
+	}
+
+	result, err := db.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(sessionsTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"code": {
+				S: aws.String(code),
+			},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result.Item == nil {
+		return nil, nil
+	}
+
+	filmSession := &Session{
+		Code:   code,
+		Swipes: map[string]map[string]string{},
+	}
+	if result.Item["ownerId"] != nil && result.Item["ownerId"].S != nil {
+		filmSession.OwnerId = *result.Item["ownerId"].S
+	}
+	for _, participant := range result.Item["participants"].L {
+		if participant.S != nil {
+			filmSession.Participants = append(filmSession.Participants, *participant.S)
+		}
+	}
+	filmSession.FilmCount = parseNumber(result.Item["filmCount"])
+	filmSession.ExpiresAt = int64(parseNumber(result.Item["expiresAt"]))
+	if filmSession.ExpiresAt < time.Now().Unix() {
+		return nil, nil
+	}
+
+	if result.Item["swipes"] != nil {
+		for participant, swipes := range result.Item["swipes"].M {
+			filmSession.Swipes[participant] = map[string]string{}
+			for position, method := range swipes.M {
+				if method.S != nil {
+					filmSession.Swipes[participant][position] = *method.S
+				}
+			}
+		}
+	}
+
+	return filmSession, nil
+}
+
+func parseNumber(value *dynamodb.AttributeValue) int {
+	if value == nil || value.N == nil {
+		return 0
+	}
+
+	number, _ := strconv.Atoi(*value.N)
+	return number
+}
+
+// joinSession adds the user to the participants, if the session is still open and not full
+func joinSession(code string, userId string) error {
+	_, err := db.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(sessionsTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"code": {
+				S: aws.String(code),
+			},
+		},
+		ConditionExpression: aws.String("expiresAt > :now AND size(participants) < :maxParticipants AND NOT contains(participants, :userId)"),
+		UpdateExpression:    aws.String("SET participants = list_append(participants, :participant), swipes.#userId = :swipes"),
+		ExpressionAttributeNames: map[string]*string{
+			"#userId": aws.String(userId),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":participant": {
+				L: []*dynamodb.AttributeValue{{S: aws.String(userId)}},
+			},
+			":userId": {
+				S: aws.String(userId),
+			},
+			":swipes": {
+				M: map[string]*dynamodb.AttributeValue{},
+			},
+			":maxParticipants": {
+				N: aws.String(strconv.Itoa(maxParticipants)),
+			},
+			":now": {
+				N: aws.String(strconv.FormatInt(time.Now().Unix(), 10)),
+			},
+		},
+	})
+	return err
+}
+
+// recordSwipe stores the swipe of the participant, a later swipe of the same film replaces it
+func recordSwipe(code string, userId string, position int, method string) error {
+	_, err := db.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(sessionsTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"code": {
+				S: aws.String(code),
+			},
+		},
+		ConditionExpression: aws.String("expiresAt > :now AND contains(participants, :userId)"),
+		UpdateExpression:    aws.String("SET swipes.#userId.#position = :method"),
+		ExpressionAttributeNames: map[string]*string{
+			"#userId":   aws.String(userId),
+			"#position": aws.String(strconv.Itoa(position)),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":userId": {
+				S: aws.String(userId),
+			},
+			":method": {
+				S: aws.String(method),
+			},
+			":now": {
+				N: aws.String(strconv.FormatInt(time.Now().Unix(), 10)),
+			},
+		},
+	})
+	return err
+}
+
+func isConditionFailed(err error) bool {
+	var awsErr awserr.Error
+	return errors.As(err, &awsErr) && awsErr.Code() == dynamodb.ErrCodeConditionalCheckFailedException
+}
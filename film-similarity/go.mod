@@ -0,0 +1,10 @@
+module finder
+
+go 1.21
+
+require (
+	github.com/aws/aws-lambda-go v1.45.0
+	github.com/aws/aws-sdk-go v1.50.5
+)
+
+require github.com/jmespath/go-jmespath v0.4.0 // indirect
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+var sess = session.Must(session.NewSession())
+var db = dynamodb.New(sess)
+
+// maxUserLikedFilms is how many of the latest liked films of every user are counted, pairs grow with its square
+var maxUserLikedFilms = 200
+
+func main() {
+	lambda.Start(handleScheduledEvent)
+}
+
+// handleScheduledEvent is run by a schedule rule. It scans liked films of all users, counts how often films
+// are liked together and stores the most similar films of every film in 'film_similarity'.
+func handleScheduledEvent(ctx context.Context, event events.CloudWatchEvent) error {
+	started := time.Now()
+	counter := newCoLikeCounter()
+	users := 0
+	err := db.ScanPagesWithContext(ctx, &dynamodb.ScanInput{
+		TableName:            aws.String("user_films"),
+		ProjectionExpression: aws.String("likedFilms"),
+	}, func(output *dynamodb.ScanOutput, lastPage bool) bool {
+		for _, item := range output.Items {
+			if item["likedFilms"] == nil {
+				continue
+			}
+			counter.addUser(toLikedFilms(item["likedFilms"].L))
+			users++
+		}
+		return true
+	})
+	if err != nil {
+		log.Printf("Got error calling Scan: %v", err)
+		return err
+	}
+	log.Printf("Liked films of %d users are counted, %d films, %d pairs", users, len(counter.films), len(counter.pairs))
+
+	similarities := counter.similarities()
+	written, err := writeSimilarities(ctx, similarities, started)
+	if err != nil {
+		log.Printf("Got error writing film similarities, %d of %d films are written: %v", written, len(similarities), err)
+		return err
+	}
+
+	log.Printf("Similar films of %d films are written in %s", written, time.Since(started))
+	return nil
+}
+
+// toLikedFilms reads the latest liked films of the user, legacy plain string entries have no TMDB id
+func toLikedFilms(entries []*dynamodb.AttributeValue) []LikedFilm {
+	likedFilms := make([]LikedFilm, 0, min(len(entries), maxUserLikedFilms))
+	seen := map[string]bool{}
+	for _, entry := range entries {
+		if len(likedFilms) == maxUserLikedFilms {
+			break
+		}
+
+		var film LikedFilm
+		if entry.S != nil {
+			film.Title = *entry.S
+		} else if title, ok := entry.M["title"]; ok && title.S != nil {
+			film.Title = *title.S
+			if tmdbId, ok := entry.M["tmdbId"]; ok && tmdbId.N != nil {
+				film.TmdbId, _ = strconv.Atoi(*tmdbId.N)
+			}
+		}
+
+		film.Key = normalizeTitle(film.Title)
+		if film.Key == "" || seen[film.Key] {
+			continue
+		}
+		seen[film.Key] = true
+		likedFilms = append(likedFilms, film)
+	}
+
+	return likedFilms
+}
+
+// normalizeTitle is the key of a film, titles are compared the same way as in get-films
+func normalizeTitle(title string) string {
+	var normalized strings.Builder
+	for _, r := range strings.ToLower(title) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			normalized.WriteRune(r)
+		}
+	}
+
+	return normalized.String()
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"math"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// Similar films of every film are kept in 'film_similarity' by the normalized title of the film. Items expire
+// via the 'expiresAt' TTL attribute, so films nobody likes anymore are dropped after a few runs of the job.
+var similarityTable = "film_similarity"
+var similarityTtl = 7 * 24 * time.Hour
+
+// minCoLikes is how many users must like both films for them to be similar, fewer is mostly chance
+var minCoLikes = 2
+var maxSimilarFilms = 50
+
+// maxBatchWriteAttempts is how many times unprocessed items of a batch are written again
+var maxBatchWriteAttempts = 5
+var batchWriteSize = 25
+
+type LikedFilm struct {
+	Key    string
+	Title  string
+	TmdbId int
+}
+
+type SimilarFilm struct {
+	LikedFilm
+	Score   float64
+	CoLikes int
+}
+
+type FilmSimilarity struct {
+	LikedFilm
+	Likes   int
+	Similar []SimilarFilm
+}
+
+// coLikeCounter counts likes of every film and of every pair of films liked by the same user.
+// Films are numbered in the order they are met, a pair is the two numbers, the smaller one first.
+type coLikeCounter struct {
+	films []LikedFilm
+	likes []int
+	index map[string]int
+	pairs map[uint64]int
+}
+
+func newCoLikeCounter() *coLikeCounter {
+	return &coLikeCounter{
+		index: map[string]int{},
+		pairs: map[uint64]int{},
+	}
+}
+
+func (c *coLikeCounter) addUser(likedFilms []LikedFilm) {
+	numbers := make([]int, 0, len(likedFilms))
+	for _, film := range likedFilms {
+		number, ok := c.index[film.Key]
+		if !ok {
+			number = len(c.films)
+			c.index[film.Key] = number
+			c.films = append(c.films, film)
+			c.likes = append(c.likes, 0)
+		}
+		if c.films[number].TmdbId == 0 && film.TmdbId != 0 {
+			c.films[number] = film
+		}
+		c.likes[number]++
+		numbers = append(numbers, number)
+	}
+
+	slices.Sort(numbers)
+	for i := range numbers {
+		for j := i + 1; j < len(numbers); j++ {
+			c.pairs[uint64(numbers[i])<<32|uint64(numbers[j])]++
+		}
+	}
+}
+
+// similarities scores every pair liked together often enough by cosine similarity of the users who liked them,
+// co-likes divided by the square root of the product of likes, and keeps the most similar films of every film
+func (c *coLikeCounter) similarities() []FilmSimilarity {
+	similar := map[int][]SimilarFilm{}
+	for pair, coLikes := range c.pairs {
+		if coLikes < minCoLikes {
+			continue
+		}
+
+		first, second := int(pair>>32), int(pair&math.MaxUint32)
+		score := float64(coLikes) / math.Sqrt(float64(c.likes[first])*float64(c.likes[second]))
+		similar[first] = append(similar[first], SimilarFilm{LikedFilm: c.films[second], Score: score, CoLikes: coLikes})
+		similar[second] = append(similar[second], SimilarFilm{LikedFilm: c.films[first], Score: score, CoLikes: coLikes})
+	}
+
+	similarities := make([]FilmSimilarity, 0, len(similar))
+	for number, films := range similar {
+		slices.SortFunc(films, func(a, b SimilarFilm) int {
+			if a.Score != b.Score {
+				return cmp.Compare(b.Score, a.Score)
+			}
+			return cmp.Compare(b.CoLikes, a.CoLikes)
+		})
+		similarities = append(similarities, FilmSimilarity{
+			LikedFilm: c.films[number],
+			Likes:     c.likes[number],
+			Similar:   films[:min(len(films), maxSimilarFilms)],
+		})
+	}
+
+	return similarities
+}
+
+// writeSimilarities replaces the items of the films and returns how many films are written
+func writeSimilarities(ctx context.Context, similarities []FilmSimilarity, computedAt time.Time) (int, error) {
+	written := 0
+	for start := 0; start < len(similarities); start += batchWriteSize {
+		batch := similarities[start:min(start+batchWriteSize, len(similarities))]
+		requests := make([]*dynamodb.WriteRequest, 0, len(batch))
+		for _, similarity := range batch {
+			requests = append(requests, &dynamodb.WriteRequest{
+				PutRequest: &dynamodb.PutRequest{
+					Item: toSimilarityItem(similarity, computedAt),
+				},
+			})
+		}
+
+		err := batchWrite(ctx, requests)
+		if err != nil {
+			return written, err
+		}
+		written += len(batch)
+	}
+
+	return written, nil
+}
+
+// batchWrite writes the requests, unprocessed ones are written again with a growing pause
+func batchWrite(ctx context.Context, requests []*dynamodb.WriteRequest) error {
+	pending := map[string][]*dynamodb.WriteRequest{similarityTable: requests}
+	for attempt := 0; attempt < maxBatchWriteAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(100<<attempt) * time.Millisecond)
+		}
+
+		output, err := db.BatchWriteItemWithContext(ctx, &dynamodb.BatchWriteItemInput{
+			RequestItems: pending,
+		})
+		if err != nil {
+			return fmt.Errorf("got error calling BatchWriteItem: %w", err)
+		}
+		if len(output.UnprocessedItems[similarityTable]) == 0 {
+			return nil
+		}
+		pending = output.UnprocessedItems
+	}
+
+	return fmt.Errorf("%d items are still unprocessed after %d attempts", len(pending[similarityTable]), maxBatchWriteAttempts)
+}
+
+func toSimilarityItem(similarity FilmSimilarity, computedAt time.Time) map[string]*dynamodb.AttributeValue {
+	similar := make([]*dynamodb.AttributeValue, 0, len(similarity.Similar))
+	for _, film := range similarity.Similar {
+		entry := toFilmAttributes(film.LikedFilm)
+		entry["score"] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatFloat(film.Score, 'f', 4, 64))}
+		entry["coLikes"] = &dynamodb.AttributeValue{N: aws.String(strconv.Itoa(film.CoLikes))}
+		similar = append(similar, &dynamodb.AttributeValue{M: entry})
+	}
+
+	item := toFilmAttributes(similarity.LikedFilm)
+	item["film"] = &dynamodb.AttributeValue{S: aws.String(similarity.Key)}
+	item["likes"] = &dynamodb.AttributeValue{N: aws.String(strconv.Itoa(similarity.Likes))}
+	item["similar"] = &dynamodb.AttributeValue{L: similar}
+	item["computedAt"] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(computedAt.Unix(), 10))}
+	item["expiresAt"] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(computedAt.Add(similarityTtl).Unix(), 10))}
+
+	return item
+}
+
+// toFilmAttributes has the title and, when it is known, the TMDB id of the film
+func toFilmAttributes(film LikedFilm) map[string]*dynamodb.AttributeValue {
+	attributes := map[string]*dynamodb.AttributeValue{
+		"title": {
+			S: aws.String(film.Title),
+		},
+	}
+	if film.TmdbId != 0 {
+		attributes["tmdbId"] = &dynamodb.AttributeValue{N: aws.String(strconv.Itoa(film.TmdbId))}
+	}
+
+	return attributes
+}
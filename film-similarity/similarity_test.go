@@ -0,0 +1,152 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+type expectedSimilar struct {
+	key     string
+	score   float64
+	coLikes int
+}
+
+func films(keys ...string) []LikedFilm {
+	likedFilms := make([]LikedFilm, 0, len(keys))
+	for _, key := range keys {
+		likedFilms = append(likedFilms, LikedFilm{Key: key, Title: key})
+	}
+
+	return likedFilms
+}
+
+func TestCoLikeCounterSimilarities(t *testing.T) {
+	tests := []struct {
+		name  string
+		users [][]LikedFilm
+		likes map[string]int
+		want  map[string][]expectedSimilar
+	}{
+		{
+			name:  "no users",
+			users: nil,
+			want:  map[string][]expectedSimilar{},
+		},
+		{
+			name:  "liked together once is chance",
+			users: [][]LikedFilm{films("heat", "alien"), films("heat", "up")},
+			want:  map[string][]expectedSimilar{},
+		},
+		{
+			name:  "liked together by every user",
+			users: [][]LikedFilm{films("heat", "alien"), films("alien", "heat")},
+			likes: map[string]int{"heat": 2, "alien": 2},
+			want: map[string][]expectedSimilar{
+				"heat":  {{"alien", 1, 2}},
+				"alien": {{"heat", 1, 2}},
+			},
+		},
+		{
+			name: "most similar first",
+			users: [][]LikedFilm{
+				films("heat", "alien", "up"),
+				films("heat", "up"),
+				films("heat", "alien"),
+				films("heat", "alien"),
+			},
+			likes: map[string]int{"heat": 4, "alien": 3, "up": 2},
+			want: map[string][]expectedSimilar{
+				"heat":  {{"alien", 3 / math.Sqrt(12), 3}, {"up", 2 / math.Sqrt(8), 2}},
+				"alien": {{"heat", 3 / math.Sqrt(12), 3}},
+				"up":    {{"heat", 2 / math.Sqrt(8), 2}},
+			},
+		},
+		{
+			name: "same score, more co-likes first",
+			users: [][]LikedFilm{
+				films("heat", "alien"),
+				films("heat", "alien"),
+				films("heat", "up"),
+				films("heat", "up"),
+				films("heat", "up"),
+				films("heat", "up"),
+				films("up"),
+				films("up"),
+				films("up"),
+				films("up"),
+			},
+			likes: map[string]int{"heat": 6, "alien": 2, "up": 8},
+			want: map[string][]expectedSimilar{
+				"heat":  {{"up", 4 / math.Sqrt(48), 4}, {"alien", 2 / math.Sqrt(12), 2}},
+				"alien": {{"heat", 2 / math.Sqrt(12), 2}},
+				"up":    {{"heat", 4 / math.Sqrt(48), 4}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			counter := newCoLikeCounter()
+			for _, likedFilms := range test.users {
+				counter.addUser(likedFilms)
+			}
+
+			similarities := counter.similarities()
+			if len(similarities) != len(test.want) {
+				t.Fatalf("similarities of %d films, expected %d", len(similarities), len(test.want))
+			}
+			for _, similarity := range similarities {
+				want, ok := test.want[similarity.Key]
+				if !ok {
+					t.Errorf("similarities of %s are not expected", similarity.Key)
+					continue
+				}
+				if similarity.Likes != test.likes[similarity.Key] {
+					t.Errorf("%s has %d likes, expected %d", similarity.Key, similarity.Likes, test.likes[similarity.Key])
+				}
+				if len(similarity.Similar) != len(want) {
+					t.Errorf("%s has %d similar films, expected %d", similarity.Key, len(similarity.Similar), len(want))
+					continue
+				}
+				for i, film := range similarity.Similar {
+					if film.Key != want[i].key || film.CoLikes != want[i].coLikes || math.Abs(film.Score-want[i].score) > 1e-9 {
+						t.Errorf("%s similar film %d is %s (%.4f, %d co-likes), expected %s (%.4f, %d co-likes)",
+							similarity.Key, i, film.Key, film.Score, film.CoLikes, want[i].key, want[i].score, want[i].coLikes)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestCoLikeCounterKeepsTmdbId(t *testing.T) {
+	counter := newCoLikeCounter()
+	counter.addUser([]LikedFilm{{Key: "heat", Title: "Heat"}, {Key: "alien", Title: "Alien"}})
+	counter.addUser([]LikedFilm{{Key: "heat", Title: "Heat", TmdbId: 949}, {Key: "alien", Title: "Alien"}})
+
+	for _, similarity := range counter.similarities() {
+		if similarity.Key == "heat" && similarity.TmdbId != 949 {
+			t.Errorf("heat has TMDB id %d, expected 949", similarity.TmdbId)
+		}
+		if similarity.Key == "alien" && similarity.Similar[0].TmdbId != 949 {
+			t.Errorf("similar film of alien has TMDB id %d, expected 949", similarity.Similar[0].TmdbId)
+		}
+	}
+}
+
+func TestCoLikeCounterLimitsSimilarFilms(t *testing.T) {
+	liked := make([]LikedFilm, 0, maxSimilarFilms+2)
+	for i := 0; i < maxSimilarFilms+2; i++ {
+		liked = append(liked, LikedFilm{Key: string(rune('a'+i/26)) + string(rune('a'+i%26))})
+	}
+
+	counter := newCoLikeCounter()
+	counter.addUser(liked)
+	counter.addUser(liked)
+
+	for _, similarity := range counter.similarities() {
+		if len(similarity.Similar) != maxSimilarFilms {
+			t.Errorf("%s has %d similar films, expected %d", similarity.Key, len(similarity.Similar), maxSimilarFilms)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"finder/common/tmdb"
+	"fmt"
+	"github.com/aws/aws-lambda-go/events"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Constraints are optional requirements to recommended films. They are put in the prompt and checked again
+// against TMDB details of every recommended film, films which do not meet them are replaced.
+type Constraints struct {
+	Genres        []string
+	ExcludeGenres []string
+	YearFrom      int
+	YearTo        int
+	MaxRuntime    int
+	Language      string
+	MinRating     float64
+	Adult         bool
+}
+
+// getConstraints reads 'genres', 'excludeGenres', 'yearFrom', 'yearTo', 'maxRuntime' (minutes),
+// 'language' (ISO 639-1, e.g. 'fr'), 'minRating' (TMDB vote average, 0-10) and 'adult' params
+func getConstraints(req events.APIGatewayProxyRequest) (Constraints, error) {
+	constraints := Constraints{
+		Genres:        lowerAll(req.MultiValueQueryStringParameters["genres"]),
+		ExcludeGenres: lowerAll(req.MultiValueQueryStringParameters["excludeGenres"]),
+		Language:      strings.ToLower(strings.TrimSpace(req.QueryStringParameters["language"])),
+	}
+
+	var err error
+	constraints.YearFrom, err = getIntParam(req, "yearFrom")
+	if err != nil {
+		return Constraints{}, err
+	}
+	constraints.YearTo, err = getIntParam(req, "yearTo")
+	if err != nil {
+		return Constraints{}, err
+	}
+	if constraints.YearFrom != 0 && constraints.YearTo != 0 && constraints.YearFrom > constraints.YearTo {
+		return Constraints{}, fmt.Errorf("yearFrom %d is after yearTo %d", constraints.YearFrom, constraints.YearTo)
+	}
+	constraints.MaxRuntime, err = getIntParam(req, "maxRuntime")
+	if err != nil {
+		return Constraints{}, err
+	}
+
+	if minRating := req.QueryStringParameters["minRating"]; minRating != "" {
+		constraints.MinRating, err = strconv.ParseFloat(minRating, 64)
+		if err != nil || constraints.MinRating < 0 || constraints.MinRating > 10 {
+			return Constraints{}, fmt.Errorf("minRating must be a number from 0 to 10, minRating - %s", minRating)
+		}
+	}
+
+	if adult := req.QueryStringParameters["adult"]; adult != "" {
+		constraints.Adult, err = strconv.ParseBool(adult)
+		if err != nil {
+			return Constraints{}, fmt.Errorf("adult must be 'true' or 'false', adult - %s", adult)
+		}
+	}
+
+	return constraints, nil
+}
+
+func getIntParam(req events.APIGatewayProxyRequest, name string) (int, error) {
+	param := req.QueryStringParameters[name]
+	if param == "" {
+		return 0, nil
+	}
+
+	value, err := strconv.Atoi(param)
+	if err != nil || value <= 0 {
+		return 0, fmt.Errorf("%s must be a positive number, %s - %s", name, name, param)
+	}
+	return value, nil
+}
+
+func lowerAll(values []string) []string {
+	lowered := make([]string, 0, len(values))
+	for _, value := range values {
+		if value = strings.ToLower(strings.TrimSpace(value)); value != "" {
+			lowered = append(lowered, value)
+		}
+	}
+
+	return lowered
+}
+
+// requirements describes the constraints for the prompt, one sentence each
+func (c Constraints) requirements() []string {
+	var requirements []string
+	if len(c.Genres) > 0 {
+		requirements = append(requirements, "every film has at least one of the genres: "+strings.Join(c.Genres, ", "))
+	}
+	if len(c.ExcludeGenres) > 0 {
+		requirements = append(requirements, "no film has any of the genres: "+strings.Join(c.ExcludeGenres, ", "))
+	}
+	if c.YearFrom != 0 {
+		requirements = append(requirements, fmt.Sprintf("films are released in %d or later", c.YearFrom))
+	}
+	if c.YearTo != 0 {
+		requirements = append(requirements, fmt.Sprintf("films are released in %d or earlier", c.YearTo))
+	}
+	if c.MaxRuntime != 0 {
+		requirements = append(requirements, fmt.Sprintf("films are at most %d minutes long", c.MaxRuntime))
+	}
+	if c.Language != "" {
+		requirements = append(requirements, "the original language of films is '"+c.Language+"' (ISO 639-1)")
+	}
+	if c.MinRating != 0 {
+		requirements = append(requirements, fmt.Sprintf("films are rated at least %.1f out of 10 on TMDB", c.MinRating))
+	}
+	if !c.Adult {
+		requirements = append(requirements, "no adult films")
+	}
+
+	return requirements
+}
+
+// mismatch returns why the film does not meet the constraints, or an empty string when it does.
+// Runtime and rating unknown to TMDB do not fail the film.
+func (c Constraints) mismatch(film tmdb.ResultRecommendedFilm) string {
+	genres := lowerAll(film.Genres)
+	if len(c.Genres) > 0 && !containsAny(genres, c.Genres) {
+		return "none of the genres " + strings.Join(c.Genres, ", ")
+	}
+	if containsAny(genres, c.ExcludeGenres) {
+		return "excluded genre"
+	}
+
+	year, err := strconv.Atoi(film.Year)
+	if (c.YearFrom != 0 || c.YearTo != 0) && err != nil {
+		return "unknown year"
+	}
+	if c.YearFrom != 0 && year < c.YearFrom {
+		return "released before " + strconv.Itoa(c.YearFrom)
+	}
+	if c.YearTo != 0 && year > c.YearTo {
+		return "released after " + strconv.Itoa(c.YearTo)
+	}
+
+	if c.MaxRuntime != 0 && film.Runtime > c.MaxRuntime {
+		return fmt.Sprintf("%d minutes long", film.Runtime)
+	}
+	if c.Language != "" && strings.ToLower(film.OriginalLanguage) != c.Language {
+		return "original language " + film.OriginalLanguage
+	}
+	if c.MinRating != 0 && film.VoteAverage != 0 && film.VoteAverage < c.MinRating {
+		return fmt.Sprintf("rated %.1f", film.VoteAverage)
+	}
+	if !c.Adult && film.Adult {
+		return "adult film"
+	}
+
+	return ""
+}
+
+func containsAny(values []string, wanted []string) bool {
+	for _, value := range wanted {
+		if slices.Contains(values, value) {
+			return true
+		}
+	}
+
+	return false
+}
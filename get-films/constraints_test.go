@@ -0,0 +1,144 @@
+package main
+
+import (
+	"finder/common/tmdb"
+	"github.com/aws/aws-lambda-go/events"
+	"reflect"
+	"testing"
+)
+
+func TestConstraintsMismatch(t *testing.T) {
+	heat := tmdb.ResultRecommendedFilm{
+		Name:             "Heat",
+		Year:             "1995",
+		Genres:           []string{"Crime", "Thriller"},
+		OriginalLanguage: "en",
+		Runtime:          170,
+		VoteAverage:      7.9,
+	}
+
+	tests := []struct {
+		name        string
+		constraints Constraints
+		film        func(film tmdb.ResultRecommendedFilm) tmdb.ResultRecommendedFilm
+		want        string
+	}{
+		{"no constraints", Constraints{}, nil, ""},
+		{"one of the genres", Constraints{Genres: []string{"drama", "crime"}}, nil, ""},
+		{"none of the genres", Constraints{Genres: []string{"comedy", "drama"}}, nil, "none of the genres comedy, drama"},
+		{"excluded genre", Constraints{ExcludeGenres: []string{"thriller"}}, nil, "excluded genre"},
+		{"within years", Constraints{YearFrom: 1990, YearTo: 1995}, nil, ""},
+		{"released before", Constraints{YearFrom: 1996}, nil, "released before 1996"},
+		{"released after", Constraints{YearTo: 1994}, nil, "released after 1994"},
+		{
+			"unknown year",
+			Constraints{YearFrom: 1990},
+			func(film tmdb.ResultRecommendedFilm) tmdb.ResultRecommendedFilm {
+				film.Year = ""
+				return film
+			},
+			"unknown year",
+		},
+		{"short enough", Constraints{MaxRuntime: 170}, nil, ""},
+		{"too long", Constraints{MaxRuntime: 120}, nil, "170 minutes long"},
+		{
+			"unknown runtime",
+			Constraints{MaxRuntime: 120},
+			func(film tmdb.ResultRecommendedFilm) tmdb.ResultRecommendedFilm {
+				film.Runtime = 0
+				return film
+			},
+			"",
+		},
+		{"same language", Constraints{Language: "en"}, nil, ""},
+		{"other language", Constraints{Language: "fr"}, nil, "original language en"},
+		{"rated high enough", Constraints{MinRating: 7.5}, nil, ""},
+		{"rated too low", Constraints{MinRating: 8}, nil, "rated 7.9"},
+		{
+			"unknown rating",
+			Constraints{MinRating: 8},
+			func(film tmdb.ResultRecommendedFilm) tmdb.ResultRecommendedFilm {
+				film.VoteAverage = 0
+				return film
+			},
+			"",
+		},
+		{
+			"adult film",
+			Constraints{},
+			func(film tmdb.ResultRecommendedFilm) tmdb.ResultRecommendedFilm {
+				film.Adult = true
+				return film
+			},
+			"adult film",
+		},
+		{
+			"adult film allowed",
+			Constraints{Adult: true},
+			func(film tmdb.ResultRecommendedFilm) tmdb.ResultRecommendedFilm {
+				film.Adult = true
+				return film
+			},
+			"",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			film := heat
+			if test.film != nil {
+				film = test.film(film)
+			}
+			if mismatch := test.constraints.mismatch(film); mismatch != test.want {
+				t.Errorf("mismatch returned %q, expected %q", mismatch, test.want)
+			}
+		})
+	}
+}
+
+func TestGetConstraints(t *testing.T) {
+	tests := []struct {
+		name        string
+		params      map[string]string
+		multiParams map[string][]string
+		want        Constraints
+		wantErr     bool
+	}{
+		{"no params", nil, nil, Constraints{Genres: []string{}, ExcludeGenres: []string{}}, false},
+		{
+			"all params",
+			map[string]string{"yearFrom": "1990", "yearTo": "1999", "maxRuntime": "120", "language": " FR ", "minRating": "7.5", "adult": "true"},
+			map[string][]string{"genres": {"Crime", " "}, "excludeGenres": {"Horror"}},
+			Constraints{
+				Genres:        []string{"crime"},
+				ExcludeGenres: []string{"horror"},
+				YearFrom:      1990,
+				YearTo:        1999,
+				MaxRuntime:    120,
+				Language:      "fr",
+				MinRating:     7.5,
+				Adult:         true,
+			},
+			false,
+		},
+		{"years reversed", map[string]string{"yearFrom": "2000", "yearTo": "1990"}, nil, Constraints{}, true},
+		{"negative runtime", map[string]string{"maxRuntime": "-90"}, nil, Constraints{}, true},
+		{"rating above 10", map[string]string{"minRating": "11"}, nil, Constraints{}, true},
+		{"adult not boolean", map[string]string{"adult": "sometimes"}, nil, Constraints{}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			constraints, err := getConstraints(events.APIGatewayProxyRequest{
+				QueryStringParameters:           test.params,
+				MultiValueQueryStringParameters: test.multiParams,
+			})
+			if (err != nil) != test.wantErr {
+				t.Fatalf("getConstraints returned error - %v, expected error - %t", err, test.wantErr)
+			}
+			if !test.wantErr && !reflect.DeepEqual(constraints, test.want) {
+				t.Errorf("getConstraints returned %+v, expected %+v", constraints, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"finder/common/tmdb"
+	"fmt"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxReplacementRounds is how many times films are requested again to replace the filtered out ones
+var maxReplacementRounds = 2
+
+// recentlyShownPeriod is how long recommended films are not recommended again
+var recentlyShownPeriod = 30 * 24 * time.Hour
+
+// ShownFilms are films the user must not get: liked, unliked, excluded and recently recommended.
+// Entries stored with TMDB id are matched by id, others by normalized title and, when known, year.
+type ShownFilms struct {
+	ids    map[int]bool
+	titles map[string]bool
+}
+
+// newShownFilms collects films shown to any of the users, a group gets no film one of its members has seen
+func newShownFilms(filmsToExclude []string, items ...map[string]*dynamodb.AttributeValue) ShownFilms {
+	shown := ShownFilms{ids: map[int]bool{}, titles: map[string]bool{}}
+	for _, film := range filmsToExclude {
+		shown.titles[normalizeTitle(film)] = true
+	}
+
+	since := time.Now().Add(-recentlyShownPeriod).Unix()
+	for _, item := range items {
+		for _, attribute := range []string{"likedFilms", "unlikedFilms"} {
+			if item[attribute] == nil {
+				continue
+			}
+			for _, entry := range item[attribute].L {
+				shown.addEntry(entry)
+			}
+		}
+
+		if item["recommendedFilms"] != nil {
+			for _, entry := range item["recommendedFilms"].L {
+				if recommendedAt(entry) >= since {
+					shown.addEntry(entry)
+				}
+			}
+		}
+	}
+
+	return shown
+}
+
+func (shown ShownFilms) addEntry(entry *dynamodb.AttributeValue) {
+	if tmdbId, ok := entry.M["tmdbId"]; ok && tmdbId.N != nil {
+		if id, err := strconv.Atoi(*tmdbId.N); err == nil && id != 0 {
+			shown.ids[id] = true
+			return
+		}
+	}
+	if title := filmTitle(entry); title != "" {
+		shown.titles[normalizeTitle(title)] = true
+	}
+}
+
+func (shown ShownFilms) add(film tmdb.ResultRecommendedFilm) {
+	shown.ids[film.ID] = true
+	shown.titles[normalizeTitle(film.Name)+film.Year] = true
+}
+
+func (shown ShownFilms) contains(film tmdb.ResultRecommendedFilm) bool {
+	title := normalizeTitle(film.Name)
+	return shown.ids[film.ID] || shown.titles[title] || shown.titles[title+film.Year]
+}
+
+func recommendedAt(entry *dynamodb.AttributeValue) int64 {
+	if recommendedAt, ok := entry.M["recommendedAt"]; ok && recommendedAt.N != nil {
+		seconds, err := strconv.ParseInt(*recommendedAt.N, 10, 64)
+		if err == nil {
+			return seconds
+		}
+	}
+
+	return 0
+}
+
+// collect asks for films, looks them up in TMDB and drops the ones the user has already seen or which do not
+// meet the constraints. Dropped films are excluded and replacements are requested until there are enough films
+// or the rounds are over, then the films found so far are returned. Only an error of the first round fails
+// the recommendation. Films are returned in the order they are suggested in, but every kept film is passed
+// to emit, when it is given, as soon as it is looked up.
+func (r Recommendation) collect(ctx context.Context, emit func(film RecommendedFilm)) ([]RecommendedFilm, error) {
+	data := r.PromptData
+	data.ExcludedFilms = append([]string{}, data.ExcludedFilms...)
+	excludedFilms := append([]string{}, r.ExcludedFilms...)
+	filmCount := data.FilmCount
+	films := make([]RecommendedFilm, 0, filmCount)
+	candidates, err := r.gatherCandidates()
+	if err != nil {
+		return nil, err
+	}
+	r.Candidates = candidates
+	for round := 0; round <= maxReplacementRounds && len(films) < filmCount; round++ {
+		data.FilmCount = filmCount - len(films)
+		prompt, suggestions, err := r.suggestFilms(ctx, data, excludedFilms)
+		if err != nil && round == 0 {
+			return nil, err
+		}
+		if err != nil {
+			log.Printf("Got error requesting replacement films, round %d, error - %v", round, err)
+			break
+		}
+
+		filmTitles := make([]tmdb.FilmTitle, 0, len(suggestions))
+		for _, suggestion := range suggestions {
+			year := ""
+			if suggestion.Year != 0 {
+				year = strconv.Itoa(suggestion.Year)
+			}
+			filmTitles = append(filmTitles, tmdb.FilmTitle{ID: suggestion.TmdbId, Name: suggestion.Title, Year: year})
+			// films of previous rounds are in the prompt, so that the model does not suggest them again
+			data.ExcludedFilms = append(data.ExcludedFilms, suggestion.Title)
+			excludedFilms = append(excludedFilms, suggestion.Title)
+		}
+
+		// films are kept in the order they are suggested in, which is the ranking of the recommender,
+		// only streamed films are kept as soon as they are looked up
+		lookedUp := make([]*RecommendedFilm, len(filmTitles))
+		tmdb.NormalizeFilmsConcurrently(filmTitles, func(index int, film tmdb.ResultRecommendedFilm, err error) {
+			if err != nil {
+				log.Printf("Film is filtered out as not found in TMDB - %s, error - %v", filmTitles[index].Name, err)
+				return
+			}
+
+			recommendedFilm := RecommendedFilm{
+				ResultRecommendedFilm: film,
+				PromptVersion:         prompt.Version,
+				Explanation:           suggestions[index].Reason,
+				BecauseYouLiked:       suggestions[index].BecauseYouLiked,
+				MemberAffinity:        r.memberAffinity(suggestions[index].MemberAffinity),
+			}
+			if emit == nil {
+				lookedUp[index] = &recommendedFilm
+				return
+			}
+			if r.keep(film, len(films), filmCount) {
+				films = append(films, recommendedFilm)
+				emit(recommendedFilm)
+			}
+		})
+		for _, recommendedFilm := range lookedUp {
+			if recommendedFilm != nil && r.keep(recommendedFilm.ResultRecommendedFilm, len(films), filmCount) {
+				films = append(films, *recommendedFilm)
+			}
+		}
+	}
+
+	return films, nil
+}
+
+// keep checks that the film is still missing, not shown to the user yet and meets the constraints.
+// A kept film is added to the shown films, so that the same film suggested twice is kept once.
+func (r Recommendation) keep(film tmdb.ResultRecommendedFilm, kept int, filmCount int) bool {
+	if kept == filmCount {
+		return false
+	}
+	if r.Shown.contains(film) {
+		log.Printf("Film is filtered out as already shown to the user - %s (%s)", film.Name, film.Year)
+		return false
+	}
+	if mismatch := r.Constraints.mismatch(film); mismatch != "" {
+		log.Printf("Film is filtered out as not meeting the constraints - %s (%s), %s", film.Name, film.Year, mismatch)
+		return false
+	}
+
+	r.Shown.add(film)
+	return true
+}
+
+// suggestFilms asks the recommender for films. Films chosen from candidates with a TMDB id are got by the id,
+// others by title. 'rerank' without enough candidates, like before the first run of the job, asks the model alone.
+// 'hybrid' recommends only candidates, with fewer candidates than films it asks for fewer films.
+func (r Recommendation) suggestFilms(ctx context.Context, data PromptData, excludedFilms []string) (Prompt, []FilmSuggestion, error) {
+	candidates := r.freshCandidates(excludedFilms)
+	switch {
+	case r.Recommender == "collaborative" && len(candidates) == 0:
+		return Prompt{}, nil, fmt.Errorf("no similar films of the liked films left")
+	case r.Recommender == "collaborative":
+		suggestions := collaborativeSuggestions(candidates, data.FilmCount)
+		log.Printf("Collaborative film recommendations: %v\n", suggestions)
+		return Prompt{Version: collaborativeVersion}, suggestions, nil
+	case r.Recommender == "hybrid" && len(candidates) == 0:
+		return Prompt{}, nil, fmt.Errorf("no TMDB or similar films of the liked films left")
+	case r.Recommender == "hybrid":
+		data.Candidates = candidateTitles(candidates[:min(len(candidates), maxRerankCandidates)])
+		if len(data.Candidates) < data.FilmCount {
+			log.Printf("Only %d candidates for %d films, fewer films are recommended", len(data.Candidates), data.FilmCount)
+			data.FilmCount = len(data.Candidates)
+		}
+	case r.Recommender == "llm":
+	case len(candidates) < data.FilmCount:
+		log.Printf("Only %d candidates for %d films, films are recommended without candidates", len(candidates), data.FilmCount)
+	default:
+		data.Candidates = candidateTitles(candidates[:min(len(candidates), maxRerankCandidates)])
+	}
+
+	prompt, err := renderFittingPrompt(r.PromptVersion, data)
+	if err != nil {
+		return Prompt{}, nil, err
+	}
+	if len(data.Candidates) > 0 && !strings.Contains(prompt.User, data.Candidates[0]) {
+		if r.Recommender == "hybrid" {
+			return Prompt{}, nil, fmt.Errorf("prompt %s has no candidates, 'hybrid' can not recommend with it", prompt.Version)
+		}
+		// prompt versions before recommendation-v8 have no candidates, the model is not held to them
+		log.Printf("Prompt %s has no candidates, films are recommended without them", prompt.Version)
+		data.Candidates = nil
+	}
+	log.Printf("Prompt %s, message content to ChatGPT - %s", prompt.Version, prompt.User)
+
+	suggestions, err := recommendFilms(ctx, prompt, data, excludedFilms)
+	if err != nil {
+		return Prompt{}, nil, err
+	}
+	if len(data.Candidates) > 0 {
+		matchCandidates(suggestions, candidates)
+	}
+	log.Printf("Film recommendations: %v\n", suggestions)
+
+	return prompt, suggestions, nil
+}
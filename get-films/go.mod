@@ -13,3 +13,7 @@ require (
 	github.com/jmespath/go-jmespath v0.4.0 // indirect
 	github.com/joho/godotenv v1.5.1 // indirect
 )
+
+require finder/common v0.0.0
+
+replace finder/common => ../common
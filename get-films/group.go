@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// maxGroupSize is the most users recommended films together
+var maxGroupSize = 8
+
+// MemberAffinity tells how much a member of a group would like the film: 'high', 'medium' or 'low'
+type MemberAffinity struct {
+	Id       string `json:"id"`
+	Affinity string `json:"affinity"`
+	Hint     string `json:"hint"`
+}
+
+// constructGroupPromptData puts the history of every member in the prompt, sampled the same way as for one user.
+// Films liked, unliked or recently recommended to any member are excluded, so a film one member dislikes is never
+// recommended to the group.
+func constructGroupPromptData(members []Member, filmCount int, filmsToExclude []string, historySampling string) (PromptData, []string) {
+	data := PromptData{FilmCount: filmCount, HasHistory: true, ExcludedFilms: filmsToExclude}
+	excludedFilms := append([]string{}, filmsToExclude...)
+	for i, member := range members {
+		memberData, memberExcludedFilms := constructPromptData(&dynamodb.GetItemOutput{Item: member.Item}, filmCount, nil)
+		memberData = sampleHistory(memberData, historySampling)
+		data.Members = append(data.Members, MemberTaste{
+			Name:         fmt.Sprintf("Member %d", i+1),
+			LikedFilms:   memberData.LikedFilms,
+			UnlikedFilms: memberData.UnlikedFilms,
+		})
+		excludedFilms = append(excludedFilms, memberExcludedFilms...)
+	}
+
+	return data, excludedFilms
+}
+
+// memberAffinity maps members named in the prompt back to their ids, affinity of unknown members is dropped
+func (r Recommendation) memberAffinity(suggested []SuggestedAffinity) []MemberAffinity {
+	var affinity []MemberAffinity
+	for _, memberAffinity := range suggested {
+		for i, member := range r.PromptData.Members {
+			if member.Name == memberAffinity.Member && i < len(r.Members) {
+				affinity = append(affinity, MemberAffinity{
+					Id:       r.Members[i].Id,
+					Affinity: memberAffinity.Affinity,
+					Hint:     memberAffinity.Hint,
+				})
+			}
+		}
+	}
+
+	return affinity
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"log"
+	"strconv"
+	"time"
+)
+
+// Recommended films are kept in 'recommendedFilms' of the user_films item, newest first, with the time and the prompt
+// version, so that prompt versions can be compared by what users liked afterwards. Only the latest are kept.
+var maxRecommendedFilms = 200
+
+// record adds films to the history of the user, or of every member of a group
+func (r Recommendation) record(films []RecommendedFilm) {
+	for _, member := range r.Members {
+		recordRecommendations(member.Id, member.Item, films)
+	}
+}
+
+// recordRecommendations adds films to the history. It is conditional on the history read before the recommendation,
+// a concurrent recommendation wins and this one is only logged, as the history is not worth failing the response.
+func recordRecommendations(userId string, item map[string]*dynamodb.AttributeValue, films []RecommendedFilm) {
+	now := time.Now()
+	history := make([]*dynamodb.AttributeValue, 0, len(films))
+	for _, film := range films {
+		history = append(history, &dynamodb.AttributeValue{
+			M: map[string]*dynamodb.AttributeValue{
+				"title": {
+					S: aws.String(film.Name),
+				},
+				"tmdbId": {
+					N: aws.String(strconv.Itoa(film.ID)),
+				},
+				"recommendedAt": {
+					N: aws.String(strconv.FormatInt(now.Unix(), 10)),
+				},
+				"promptVersion": {
+					S: aws.String(film.PromptVersion),
+				},
+			},
+		})
+	}
+
+	conditionExpression := "attribute_not_exists(recommendedFilms)"
+	values := map[string]*dynamodb.AttributeValue{
+		":empty": {
+			L: []*dynamodb.AttributeValue{},
+		},
+	}
+	if item != nil && item["recommendedFilms"] != nil {
+		history = append(history, item["recommendedFilms"].L...)
+		conditionExpression = "recommendedFilms = :oldRecommendedFilms"
+		values[":oldRecommendedFilms"] = item["recommendedFilms"]
+	}
+	if len(history) > maxRecommendedFilms {
+		history = history[:maxRecommendedFilms]
+	}
+	values[":recommendedFilms"] = &dynamodb.AttributeValue{L: history}
+
+	_, err := db.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String("user_films"),
+		Key: map[string]*dynamodb.AttributeValue{
+			"id": {
+				S: aws.String(userId),
+			},
+		},
+		ConditionExpression: aws.String(conditionExpression),
+		// lists are created as well, other lambdas expect them on every item
+		UpdateExpression: aws.String("SET recommendedFilms = :recommendedFilms, " +
+			"likedFilms = if_not_exists(likedFilms, :empty), unlikedFilms = if_not_exists(unlikedFilms, :empty)"),
+		ExpressionAttributeValues: values,
+	})
+	if err != nil {
+		log.Printf("Got error recording recommended films, user id - %s, error - %v", userId, err)
+	}
+}
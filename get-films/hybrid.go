@@ -0,0 +1,92 @@
+package main
+
+import (
+	"cmp"
+	"finder/common/tmdb"
+	"log"
+	"slices"
+)
+
+// The 'hybrid' recommender gathers films from TMDB before asking the model: films TMDB recommends for the latest
+// liked films and films similar to them, and popular films of the genres liked most, joined with similar films
+// of the job which have TMDB id. The model only chooses from these films, so every film it recommends exists
+// in TMDB and is got by its id. With fewer candidates
+// than films requested, fewer films are recommended.
+var maxHybridSeeds = 5
+var maxHybridSeedsTotal = 10
+var maxDiscoverGenres = 3
+
+// discoverWeight puts popular films of liked genres below films recommended for a liked film of the same rank
+var discoverWeight = 0.5
+
+// tmdbCandidates scores every film by its rank in every list, a film in several lists gets the sum.
+// Lists which cannot be read are logged and skipped. Users without liked films get popular films of any genre.
+// Lists are cached by the tmdb package, so only lists of newly liked films are read from TMDB.
+func tmdbCandidates(members []Member) []Candidate {
+	seeds := latestLikedFilms(members, maxHybridSeeds, maxHybridSeedsTotal)
+	details := tmdb.GetFilmsDetails(seeds)
+
+	candidates := map[string]*Candidate{}
+	add := func(movies []tmdb.MovieMatch, seed string, weight float64) {
+		for rank, movie := range movies {
+			key := normalizeTitle(movie.Title)
+			if key == "" {
+				continue
+			}
+
+			candidate, ok := candidates[key]
+			if !ok {
+				candidate = &Candidate{Title: movie.Title, Year: movie.Year, TmdbId: movie.ID, similarTo: map[string]float64{}}
+				candidates[key] = candidate
+			}
+			score := weight / float64(rank+1)
+			candidate.Score += score
+			if seed != "" {
+				candidate.similarTo[seed] += score
+			}
+		}
+	}
+
+	movieIds := make([]int, 0, len(seeds))
+	genreCounts := map[string]int{}
+	for _, seed := range seeds {
+		if detail, ok := details[seed]; ok {
+			movieIds = append(movieIds, detail.ID)
+			for _, genre := range detail.Genres {
+				genreCounts[genre]++
+			}
+		}
+	}
+
+	related := tmdb.GetRelatedMovies(movieIds)
+	for _, seed := range seeds {
+		if detail, ok := details[seed]; ok {
+			add(related[detail.ID].Recommendations, seed, 1)
+			add(related[detail.ID].Similar, seed, 1)
+		}
+	}
+
+	movies, err := tmdb.DiscoverMovies(topGenres(genreCounts, maxDiscoverGenres))
+	if err != nil {
+		log.Printf("Got error discovering TMDB candidates, error - %v", err)
+	}
+	add(movies, "", discoverWeight)
+
+	return sortedCandidates(candidates)
+}
+
+// topGenres returns the genres of most liked films, the most liked first
+func topGenres(genreCounts map[string]int, count int) []string {
+	genres := make([]string, 0, len(genreCounts))
+	for genre := range genreCounts {
+		genres = append(genres, genre)
+	}
+	slices.SortFunc(genres, func(a, b string) int {
+		if genreCounts[a] != genreCounts[b] {
+			return cmp.Compare(genreCounts[b], genreCounts[a])
+		}
+		return cmp.Compare(a, b)
+	})
+
+	return genres[:min(len(genres), count)]
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"github.com/aws/aws-lambda-go/events"
+	"log"
+	"net/http"
+)
+
+// serveLocally serves the lambda on '/get-films' for local development. Films are streamed with
+// the 'stream' param the same way as by the Function URL, without it the whole response is returned.
+func serveLocally(address string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/get-films", func(w http.ResponseWriter, r *http.Request) {
+		req := toProxyRequest(r.URL.RawQuery)
+		format, errorResponse := getStreamFormat(req, "")
+		if errorResponse != nil {
+			writeLocalResponse(w, *errorResponse)
+			return
+		}
+		if format == "" {
+			response, _ := handleRequest(r.Context(), req)
+			writeLocalResponse(w, response)
+			return
+		}
+
+		recommendation, errorResponse := prepareRecommendation(req)
+		if errorResponse != nil {
+			writeLocalResponse(w, *errorResponse)
+			return
+		}
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", streamFormats[format])
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		streamRecommendations(r.Context(), recommendation, format, w, flusher.Flush)
+	})
+
+	log.Printf("Serving get-films on %s", address)
+	return http.ListenAndServe(address, mux)
+}
+
+func writeLocalResponse(w http.ResponseWriter, response events.APIGatewayProxyResponse) {
+	for name, value := range response.Headers {
+		w.Header().Set(name, value)
+	}
+	w.WriteHeader(response.StatusCode)
+	_, err := w.Write([]byte(response.Body))
+	if err != nil {
+		log.Printf("Got error writing response: %v", err)
+	}
+}
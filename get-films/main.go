@@ -3,177 +3,308 @@ package main
 import (
 	"context"
 	"encoding/json"
-	"finder/tmdb"
+	"finder/common/tmdb"
+	"fmt"
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/google/uuid"
-	"github.com/sashabaranov/go-openai"
 	"log"
 	"os"
+	"slices"
 	"strconv"
-	"strings"
+	"time"
 )
 
 var sess = session.Must(session.NewSession())
 var db = dynamodb.New(sess)
 
-var recommendationTemplateBeginning = "Recommend me exactly {filmCount} film."
-var recommendationTemplateEnding = "\nDo not include mentioned films.\nProvide me response in the json form of an array of strings with name 'films'."
-
+// The lambda runs behind API Gateway by default. With 'StreamResponses' environment variable set to 'true'
+// it is served by a Function URL in RESPONSE_STREAM mode and has to be built with '-tags lambda.norpc'.
+// With 'PrefetchWorker' set to 'true' it is the SQS worker filling recommendation queues.
+// With 'LocalServerAddress' set it runs as a local HTTP server instead.
 func main() {
+	if address := os.Getenv("LocalServerAddress"); address != "" {
+		log.Fatal(serveLocally(address))
+	}
+	if os.Getenv("PrefetchWorker") == "true" {
+		lambda.Start(handlePrefetchEvents)
+	}
+	if os.Getenv("StreamResponses") == "true" {
+		lambda.Start(handleStreamingRequest)
+	}
 	lambda.Start(handleRequest)
 }
 
 func handleRequest(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
-	filmCount := getFilmCount(req)
-	userId, err := getUserIdAndVerify(req)
-	filmsToExclude := req.MultiValueQueryStringParameters["filmsToExclude"]
+	recommendation, errorResponse := prepareRecommendation(req)
+	if errorResponse != nil {
+		return *errorResponse, nil
+	}
+
+	films, err := recommendation.recommend(ctx, nil)
 	if err != nil {
-		id := req.QueryStringParameters["id"]
-		log.Fatalf("Provided user id is not correct, user id - %s", id)
+		log.Printf("Got error getting film recommendations: %v", err)
 		return events.APIGatewayProxyResponse{
-			StatusCode: 400,
-			Body:       "Provided user id is not correct, user id - " + id,
-		}, err
+			StatusCode: 500,
+			Body:       "Got error getting film recommendations: " + err.Error(),
+		}, nil
 	}
+	recommendation.record(films)
 
-	result, err := db.GetItem(&dynamodb.GetItemInput{
-		TableName: aws.String("user_films"),
-		Key: map[string]*dynamodb.AttributeValue{
-			"id": {
-				S: aws.String(userId),
-			},
-		},
-	})
+	jsonFilms, err := json.Marshal(films)
 	if err != nil {
-		log.Fatalf("Got error calling GetItem: %s", err)
+		log.Printf("Got error parsing to result JSON: %v", films)
 		return events.APIGatewayProxyResponse{
 			StatusCode: 500,
-			Body:       "Got error calling GetItem: " + err.Error(),
-		}, err
+			Body:       "Got error parsing films to result JSON: " + err.Error(),
+		}, nil
 	}
 
-	messageContent := constructMessageContent(result, filmCount, filmsToExclude)
-	log.Printf("Prompt, message content to ChatGPT - %s", messageContent)
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Body:       string(jsonFilms),
+	}, nil
+}
+
+// Recommendation is a request for films read from the params and the user history. For a group UserId and Item
+// are of the first member.
+type Recommendation struct {
+	UserId        string
+	Item          map[string]*dynamodb.AttributeValue
+	Members       []Member
+	PromptVersion string
+	PromptData    PromptData
+	ExcludedFilms []string
+	Shown         ShownFilms
+	Constraints   Constraints
+	Recommender   string
+	// Candidates are films to choose from, gathered when films are requested for recommenders other than 'llm'
+	Candidates []Candidate
+	// Prefetchable requests have no params changing the prompt, so prefetched films suit them
+	Prefetchable bool
+}
 
-	//chatGpt request
-	client := openai.NewClient(os.Getenv("OpenAIToken"))
-	resp, err := client.CreateChatCompletion(
-		context.Background(),
-		openai.ChatCompletionRequest{
-			ResponseFormat: &openai.ChatCompletionResponseFormat{
-				Type: openai.ChatCompletionResponseFormatTypeJSONObject,
-			},
-			Model: openai.GPT4o,
-			Messages: []openai.ChatCompletionMessage{
-				{
-					Role: openai.ChatMessageRoleSystem,
-					Content: "You are an expert in film recommendations and an experienced cinema critique designed to output JSON. " +
-						"You recommend films, do not ask questions, just generate film ideas, write only film names. I give you films I like and films I do not like. " +
-						"Also I give you films I do not want to see in your film recommendation list. Based on this, you will generate me film ideas.",
-				},
-				{
-					Role:    openai.ChatMessageRoleUser,
-					Content: messageContent,
+// prepareRecommendation reads the params and the user history, or returns the response when they are not correct
+func prepareRecommendation(req events.APIGatewayProxyRequest) (Recommendation, *events.APIGatewayProxyResponse) {
+	filmCount := getFilmCount(req)
+	userIds, err := getUserIdsAndVerify(req)
+	filmsToExclude := req.MultiValueQueryStringParameters["filmsToExclude"]
+	if err != nil {
+		log.Printf("Provided user id is not correct: %v", err)
+		return Recommendation{}, &events.APIGatewayProxyResponse{
+			StatusCode: 400,
+			Body:       "Provided user id is not correct, " + err.Error(),
+		}
+	}
+
+	members := make([]Member, 0, len(userIds))
+	for _, userId := range userIds {
+		result, err := db.GetItem(&dynamodb.GetItemInput{
+			TableName: aws.String("user_films"),
+			Key: map[string]*dynamodb.AttributeValue{
+				"id": {
+					S: aws.String(userId),
 				},
 			},
-		},
-	)
+		})
+		if err != nil {
+			log.Printf("Got error calling GetItem: %s", err)
+			return Recommendation{}, &events.APIGatewayProxyResponse{
+				StatusCode: 500,
+				Body:       "Got error calling GetItem: " + err.Error(),
+			}
+		}
+		members = append(members, Member{Id: userId, Item: result.Item})
+	}
+
+	promptVersion, err := getPromptVersion(req.QueryStringParameters["promptVersion"])
 	if err != nil {
-		log.Fatalf("ChatCompletion error: %v\n", err)
-		return events.APIGatewayProxyResponse{
-			StatusCode: 500,
-			Body:       "ChatCompletion error: " + err.Error(),
-		}, err
+		log.Printf("Provided prompt version is not correct: %v", err)
+		return Recommendation{}, &events.APIGatewayProxyResponse{
+			StatusCode: 400,
+			Body:       "Provided prompt version is not correct: " + err.Error(),
+		}
+	}
+
+	historySampling := req.QueryStringParameters["historySampling"]
+	if historySampling == "" {
+		historySampling = "recent"
+	}
+	if !slices.Contains(historySamplings, historySampling) {
+		log.Printf("Provided history sampling is not correct, history sampling - %s", historySampling)
+		return Recommendation{}, &events.APIGatewayProxyResponse{
+			StatusCode: 400,
+			Body:       "Provided history sampling is not correct, expected 'recent' or 'genres', history sampling - " + historySampling,
+		}
 	}
 
-	var filmRecommendationsObject FilmRecommendations
-	err = json.Unmarshal([]byte(resp.Choices[0].Message.Content), &filmRecommendationsObject)
+	constraints, err := getConstraints(req)
 	if err != nil {
-		log.Println(resp.Choices[0].Message.Content)
-		log.Fatalf("Error while parsing ChatGPT response as an object. Error message - %v", err)
-		return events.APIGatewayProxyResponse{
-			StatusCode: 500,
-			Body:       "Error while parsing ChatGPT response: " + err.Error(),
-		}, err
+		log.Printf("Provided constraints are not correct: %v", err)
+		return Recommendation{}, &events.APIGatewayProxyResponse{
+			StatusCode: 400,
+			Body:       "Provided constraints are not correct: " + err.Error(),
+		}
 	}
-	filmRecommendationsArray := filmRecommendationsObject.Films
 
-	log.Printf("Film recommendations: %v\n", filmRecommendationsArray)
+	mood, err := getMood(req)
+	if err != nil {
+		log.Printf("Provided mood is not correct: %v", err)
+		return Recommendation{}, &events.APIGatewayProxyResponse{
+			StatusCode: 400,
+			Body:       "Provided mood is not correct: " + err.Error(),
+		}
+	}
 
-	films, err := tmdb.NormalizeFilms(filmRecommendationsArray)
+	recommender, err := getRecommender(req.QueryStringParameters["recommender"])
 	if err != nil {
-		log.Fatalf("Error while parsing ChatGPT response. Error message - %v", err)
-		return events.APIGatewayProxyResponse{
-			StatusCode: 500,
-			Body:       "Error while normalizing films. Error - " + err.Error(),
-		}, err
+		log.Printf("Provided recommender is not correct: %v", err)
+		return Recommendation{}, &events.APIGatewayProxyResponse{
+			StatusCode: 400,
+			Body:       "Provided recommender is not correct, " + err.Error(),
+		}
+	}
+	if recommender == "hybrid" && !takesCandidates(promptVersion) {
+		log.Printf("Prompt version %s has no candidates for 'hybrid' recommender", promptVersion)
+		return Recommendation{}, &events.APIGatewayProxyResponse{
+			StatusCode: 400,
+			Body:       "Provided recommender is not correct, 'hybrid' needs a prompt version with candidates, like recommendation-v8, prompt version - " + promptVersion,
+		}
+	}
+	items := make([]map[string]*dynamodb.AttributeValue, 0, len(members))
+	for _, member := range members {
+		items = append(items, member.Item)
 	}
 
-	return events.APIGatewayProxyResponse{
-		StatusCode: 200,
-		Body:       films,
+	var promptData PromptData
+	var excludedFilms []string
+	if len(members) == 1 {
+		promptData, excludedFilms = constructPromptData(&dynamodb.GetItemOutput{Item: members[0].Item}, filmCount, filmsToExclude)
+		promptData = sampleHistory(promptData, historySampling)
+	} else {
+		promptData, excludedFilms = constructGroupPromptData(members, filmCount, filmsToExclude, historySampling)
+	}
+	promptData.Requirements = constraints.requirements()
+	promptData.Mood = mood
+
+	return Recommendation{
+		UserId:        members[0].Id,
+		Item:          members[0].Item,
+		Members:       members,
+		PromptVersion: promptVersion,
+		PromptData:    promptData,
+		ExcludedFilms: excludedFilms,
+		Shown:         newShownFilms(filmsToExclude, items...),
+		Constraints:   constraints,
+		Recommender:   recommender,
+		Prefetchable: len(members) == 1 && mood == "" && req.QueryStringParameters["promptVersion"] == "" &&
+			req.QueryStringParameters["historySampling"] == "" && req.QueryStringParameters["recommender"] == "",
 	}, nil
 }
 
-func getUserIdAndVerify(req events.APIGatewayProxyRequest) (string, error) {
-	userId, err := uuid.Parse(req.QueryStringParameters["id"])
+type Member struct {
+	Id   string
+	Item map[string]*dynamodb.AttributeValue
+}
+
+// getUserIdsAndVerify reads 'id' param, repeated for a group watching together
+func getUserIdsAndVerify(req events.APIGatewayProxyRequest) ([]string, error) {
+	ids := req.MultiValueQueryStringParameters["id"]
+	if len(ids) == 0 {
+		ids = []string{req.QueryStringParameters["id"]}
+	}
+	if len(ids) > maxGroupSize {
+		return nil, fmt.Errorf("a group is at most %d users, got %d", maxGroupSize, len(ids))
+	}
+
+	userIds := make([]string, 0, len(ids))
+	for _, id := range ids {
+		userId, err := uuid.Parse(id)
+		if err != nil {
+			return nil, fmt.Errorf("user id - %s", id)
+		}
+		if !slices.Contains(userIds, userId.String()) {
+			userIds = append(userIds, userId.String())
+		}
+	}
 
-	return userId.String(), err
+	return userIds, nil
 }
 
-func getFilmCount(req events.APIGatewayProxyRequest) string {
+func getFilmCount(req events.APIGatewayProxyRequest) int {
 	filmCount := req.QueryStringParameters["filmCount"]
 	if filmCount == "" {
-		return "5"
+		return 5
 	}
 
 	filmCountInt, err := strconv.Atoi(filmCount)
 	if filmCountInt <= 0 || err != nil {
-		return "5"
+		return 5
 	}
 
-	return filmCount
+	return filmCountInt
 }
 
-func constructMessageContent(result *dynamodb.GetItemOutput, filmCount string, filmsToExclude []string) string {
-	var messageContent string
-	if result.Item != nil {
-		excludedFilms := filmsToExclude
-		var unlikedFilms []string
-		var likedFilms []string
+// constructPromptData builds the prompt from the whole history and the films the recommendations are checked against.
+// Liked and unliked films are excluded on the server, so only 'filmsToExclude' are repeated in the prompt.
+func constructPromptData(result *dynamodb.GetItemOutput, filmCount int, filmsToExclude []string) (PromptData, []string) {
+	data := PromptData{FilmCount: filmCount}
+	excludedFilms := append([]string{}, filmsToExclude...)
+	if result.Item == nil {
+		return data, excludedFilms
+	}
+
+	data.HasHistory = true
+	data.ExcludedFilms = filmsToExclude
+	if result.Item["unlikedFilms"] != nil {
 		for _, v := range result.Item["unlikedFilms"].L {
-			if v.S != nil {
-				excludedFilms = append(excludedFilms, *v.S)
-				unlikedFilms = append(unlikedFilms, *v.S)
+			if title := filmTitle(v); title != "" {
+				excludedFilms = append(excludedFilms, title)
+				data.UnlikedFilms = append(data.UnlikedFilms, title)
 			}
 		}
+	}
+	if result.Item["likedFilms"] != nil {
 		for _, v := range result.Item["likedFilms"].L {
-			if v.S != nil {
-				excludedFilms = append(excludedFilms, *v.S)
-				likedFilms = append(likedFilms, *v.S)
+			if title := filmTitle(v); title != "" {
+				excludedFilms = append(excludedFilms, title)
+				data.LikedFilms = append(data.LikedFilms, title)
 			}
 		}
-
-		messageContent = recommendationTemplateBeginning
-		if len(likedFilms) > 0 {
-			messageContent = messageContent + "\nI like the following films: " + strings.Join(likedFilms, ", ") + "."
-		}
-		if len(unlikedFilms) > 0 {
-			messageContent = messageContent + "\nI do not like the following films: " + strings.Join(unlikedFilms, ", ") + "."
+	}
+	if result.Item["recommendedFilms"] != nil {
+		since := time.Now().Add(-recentlyShownPeriod).Unix()
+		for _, v := range result.Item["recommendedFilms"].L {
+			if title := filmTitle(v); title != "" && recommendedAt(v) >= since {
+				excludedFilms = append(excludedFilms, title)
+			}
 		}
+	}
 
-		messageContent = messageContent + "\nExclude the following films: " + strings.Join(excludedFilms, ", ") + recommendationTemplateEnding
-	} else {
-		messageContent = recommendationTemplateBeginning + recommendationTemplateEnding
+	return data, excludedFilms
+}
+
+// filmTitle returns the title of a liked/unliked list entry, either a legacy plain string or a map with 'title'
+func filmTitle(film *dynamodb.AttributeValue) string {
+	if film.S != nil {
+		return *film.S
+	}
+	if title, ok := film.M["title"]; ok && title.S != nil {
+		return *title.S
 	}
 
-	return strings.ReplaceAll(messageContent, "{filmCount}", filmCount)
+	return ""
 }
 
-type FilmRecommendations struct {
-	Films []string `json:"films"`
+// RecommendedFilm is a film of the response, with the version of the prompt it was recommended by
+// and the explanation why, referencing liked films of the user in 'becauseYouLiked'
+type RecommendedFilm struct {
+	tmdb.ResultRecommendedFilm
+	PromptVersion   string           `json:"promptVersion"`
+	Explanation     string           `json:"explanation"`
+	BecauseYouLiked []string         `json:"becauseYouLiked"`
+	MemberAffinity  []MemberAffinity `json:"memberAffinity,omitempty"`
 }
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"github.com/aws/aws-lambda-go/events"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxMoodLength is the longest mood in characters, a mood is a wish like "something light for a rainy Sunday"
+var maxMoodLength = 200
+
+// injectionPatterns are phrases which try to change the instructions of the model instead of describing films
+var injectionPatterns = regexp.MustCompile(`(?i)(ignore|disregard|override)\s+(all\s+|any\s+|the\s+|your\s+)?(previous|prior|above|earlier)\s+` +
+	`(instructions|prompts?|messages?|rules)|system\s+prompt|you\s+are\s+now|new\s+instructions|jailbreak`)
+
+// getMood reads 'mood' param, or 'query' when there is no mood. The mood goes in the prompt as the user wrote it,
+// so it is cleaned of control characters and markup, limited in length, and rejected when it looks like instructions.
+func getMood(req events.APIGatewayProxyRequest) (string, error) {
+	mood := req.QueryStringParameters["mood"]
+	if mood == "" {
+		mood = req.QueryStringParameters["query"]
+	}
+
+	mood = strings.Map(func(r rune) rune {
+		switch {
+		case unicode.IsControl(r) || unicode.IsSpace(r):
+			return ' '
+		case r == '<' || r == '>' || r == '`' || r == '{' || r == '}':
+			return -1
+		case !unicode.IsPrint(r):
+			return -1
+		}
+		return r
+	}, mood)
+	mood = strings.Join(strings.Fields(mood), " ")
+
+	if injectionPatterns.MatchString(mood) {
+		return "", fmt.Errorf("mood must describe films, not give instructions")
+	}
+
+	if utf8.RuneCountInString(mood) > maxMoodLength {
+		return "", fmt.Errorf("mood must be at most %d characters long", maxMoodLength)
+	}
+	return mood, nil
+}
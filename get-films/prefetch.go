@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-lambda-go/events"
+	"log"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// SQSBatchResponse reports messages which failed, SQS delivers only them again.
+// The event source mapping needs 'ReportBatchItemFailures' enabled.
+type SQSBatchResponse struct {
+	BatchItemFailures []SQSBatchItemFailure `json:"batchItemFailures"`
+}
+
+type SQSBatchItemFailure struct {
+	ItemIdentifier string `json:"itemIdentifier"`
+}
+
+// handlePrefetchEvents is the worker filling queues of users, the lambda runs it with 'PrefetchWorker' set to 'true'.
+// Every queue is filled at most once per batch, however many messages of the user there are. When a message
+// of a user fails, all messages of the user are delivered again, so that they stay in order.
+func handlePrefetchEvents(ctx context.Context, event events.SQSEvent) (SQSBatchResponse, error) {
+	response := SQSBatchResponse{BatchItemFailures: []SQSBatchItemFailure{}}
+
+	var userIds []string
+	messageIds := map[string][]string{}
+	prefetched := map[string]bool{}
+	failed := map[string]bool{}
+	for _, record := range event.Records {
+		var message PrefetchMessage
+		err := json.Unmarshal([]byte(record.Body), &message)
+		if err != nil {
+			log.Printf("Got error parsing prefetch message, it is skipped, message id - %s, error - %v", record.MessageId, err)
+			continue
+		}
+
+		if _, ok := messageIds[message.Id]; !ok {
+			userIds = append(userIds, message.Id)
+		}
+		messageIds[message.Id] = append(messageIds[message.Id], record.MessageId)
+		if failed[message.Id] {
+			continue
+		}
+		if message.Type != "profile-changed" {
+			prefetched[message.Id] = true
+			continue
+		}
+
+		// a change which drops no queued films does not start a recommendation, so swipes of a user
+		// without a queue do not, the queue of such a user is filled when the user asks for films
+		dropped, err := invalidateQueue(message)
+		if err != nil {
+			log.Printf("Got error dropping queued films, user id - %s, error - %v", message.Id, err)
+			failed[message.Id] = true
+			continue
+		}
+		prefetched[message.Id] = prefetched[message.Id] || dropped > 0
+	}
+
+	for _, userId := range userIds {
+		if prefetched[userId] && !failed[userId] {
+			err := prefetch(ctx, userId)
+			if err != nil {
+				log.Printf("Got error prefetching films, user id - %s, error - %v", userId, err)
+				failed[userId] = true
+			}
+		}
+		if !failed[userId] {
+			continue
+		}
+		for _, messageId := range messageIds[userId] {
+			response.BatchItemFailures = append(response.BatchItemFailures, SQSBatchItemFailure{ItemIdentifier: messageId})
+		}
+	}
+
+	return response, nil
+}
+
+// invalidateQueue drops queued films made for the old taste: rated films, films explained by a removed liked film,
+// or all films when the history was cleared, restored or imported. It returns how many films were dropped.
+func invalidateQueue(message PrefetchMessage) (int, error) {
+	changedFilms := map[string]bool{}
+	for _, film := range message.Films {
+		changedFilms[normalizeTitle(film)] = true
+	}
+
+	for attempts := 0; attempts < maxRetries; attempts++ {
+		queued, oldFilms, err := readQueue(message.Id)
+		if err != nil || oldFilms == nil {
+			return 0, err
+		}
+
+		var left []QueuedFilm
+		for _, film := range queued {
+			stale := false
+			switch message.Change {
+			case "cleared", "restored", "imported":
+				stale = true
+			case "deleted":
+				for _, likedFilm := range film.Film.BecauseYouLiked {
+					stale = stale || changedFilms[normalizeTitle(likedFilm)]
+				}
+			default:
+				stale = changedFilms[normalizeTitle(film.Film.Name)]
+			}
+			if !stale {
+				left = append(left, film)
+			}
+		}
+		if len(left) == len(queued) {
+			return 0, nil
+		}
+		log.Printf("Dropped %d queued films after profile change '%s', user id - %s", len(queued)-len(left), message.Change, message.Id)
+
+		err = writeQueue(message.Id, left, oldFilms)
+		if err == nil {
+			return len(queued) - len(left), nil
+		}
+		if !isConditionFailed(err) {
+			return 0, fmt.Errorf("got error calling UpdateItem: %w", err)
+		}
+	}
+
+	return 0, fmt.Errorf("queue was changed concurrently %d times", maxRetries)
+}
+
+// prefetch recommends films for the queue of the user with the default params, until the queue is full.
+// A queue which is not below the threshold any more is left as it is, so a repeated request only reads it.
+func prefetch(ctx context.Context, userId string) error {
+	queued, _, err := readQueue(userId)
+	if err != nil {
+		return err
+	}
+	if len(queued) >= prefetchThreshold {
+		return nil
+	}
+
+	query := url.Values{"id": {userId}, "filmCount": {strconv.Itoa(prefetchQueueSize - len(queued))}}
+	recommendation, errorResponse := prepareRecommendation(toProxyRequest(query.Encode()))
+	if errorResponse != nil {
+		return fmt.Errorf("got error preparing recommendation: %d %s", errorResponse.StatusCode, errorResponse.Body)
+	}
+	for _, film := range queued {
+		recommendation.Shown.add(film.Film.ResultRecommendedFilm)
+		recommendation.ExcludedFilms = append(recommendation.ExcludedFilms, film.Film.Name)
+	}
+
+	films, err := recommendation.collect(ctx, nil)
+	if err != nil {
+		return err
+	}
+	log.Printf("Prefetched %d films, user id - %s", len(films), userId)
+
+	return appendToQueue(userId, films)
+}
+
+// appendToQueue adds films to the end of the queue, skipping films which got there meanwhile
+func appendToQueue(userId string, films []RecommendedFilm) error {
+	for attempts := 0; attempts < maxRetries; attempts++ {
+		queued, oldFilms, err := readQueue(userId)
+		if err != nil {
+			return err
+		}
+
+		present := map[int]bool{}
+		for _, film := range queued {
+			present[film.Film.ID] = true
+		}
+		now := time.Now().Unix()
+		for _, film := range films {
+			if !present[film.ID] && len(queued) < prefetchQueueSize {
+				queued = append(queued, QueuedFilm{Film: film, QueuedAt: now})
+			}
+		}
+
+		err = writeQueue(userId, queued, oldFilms)
+		if err == nil {
+			return nil
+		}
+		if !isConditionFailed(err) {
+			return fmt.Errorf("got error calling UpdateItem: %w", err)
+		}
+	}
+
+	return fmt.Errorf("queue was changed concurrently %d times", maxRetries)
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+	"unicode/utf8"
+)
+
+// Prompts are versioned templates in 'prompts', one file per version, each defining 'system' and 'user' templates.
+// A new version is a new file, existing files are not changed, so recommendations stay comparable by version.
+//
+//go:embed prompts/*.tmpl
+var promptFiles embed.FS
+
+var defaultPromptVersion = "recommendation-v8"
+
+var promptTemplates = loadPromptTemplates()
+
+// The prompt has to fit the context window of the model together with the answer and the re-asks,
+// it is kept to a budget of 'PromptTokenBudget' environment variable, 4000 tokens by default
+var modelContextWindow = 128000
+var reservedResponseTokens = 8000
+var defaultPromptTokenBudget = 4000
+
+type PromptData struct {
+	FilmCount     int
+	HasHistory    bool
+	LikedFilms    []string
+	UnlikedFilms  []string
+	ExcludedFilms []string
+	Requirements  []string
+	Mood          string
+	Members       []MemberTaste
+	// Candidates are the only films the model may choose from, when there are any
+	Candidates []string
+}
+
+// MemberTaste is the history of one member of a group, named 'Member 1', 'Member 2' and so on in the prompt
+type MemberTaste struct {
+	Name         string
+	LikedFilms   []string
+	UnlikedFilms []string
+}
+
+type Prompt struct {
+	Version string
+	System  string
+	User    string
+}
+
+func loadPromptTemplates() map[string]*template.Template {
+	files, err := promptFiles.ReadDir("prompts")
+	if err != nil {
+		panic(err)
+	}
+
+	templates := make(map[string]*template.Template, len(files))
+	for _, file := range files {
+		version := strings.TrimSuffix(file.Name(), ".tmpl")
+		templates[version] = template.Must(template.New(version).
+			Funcs(template.FuncMap{"join": strings.Join}).
+			ParseFS(promptFiles, path.Join("prompts", file.Name())))
+	}
+
+	return templates
+}
+
+// getPromptVersion picks the version from 'promptVersion' param, then from 'PromptVersion' environment variable
+func getPromptVersion(requested string) (string, error) {
+	version := requested
+	if version == "" {
+		version = os.Getenv("PromptVersion")
+	}
+	if version == "" {
+		version = defaultPromptVersion
+	}
+
+	if _, ok := promptTemplates[version]; !ok {
+		return "", fmt.Errorf("unknown prompt version - %s", version)
+	}
+	return version, nil
+}
+
+// takesCandidates tells whether the prompt version holds the model to candidates, versions before
+// recommendation-v8 leave them out
+func takesCandidates(version string) bool {
+	candidate := "Candidate Film (2000)"
+	prompt, err := renderPrompt(version, PromptData{FilmCount: 1, Candidates: []string{candidate}})
+
+	return err == nil && strings.Contains(prompt.User, candidate)
+}
+
+func renderPrompt(version string, data PromptData) (Prompt, error) {
+	promptTemplate, ok := promptTemplates[version]
+	if !ok {
+		return Prompt{}, fmt.Errorf("unknown prompt version - %s", version)
+	}
+
+	var system, user bytes.Buffer
+	err := promptTemplate.ExecuteTemplate(&system, "system", data)
+	if err != nil {
+		return Prompt{}, err
+	}
+	err = promptTemplate.ExecuteTemplate(&user, "user", data)
+	if err != nil {
+		return Prompt{}, err
+	}
+
+	return Prompt{Version: version, System: system.String(), User: user.String()}, nil
+}
+
+// renderFittingPrompt renders the prompt and, while it is over the token budget, drops the oldest quarter
+// of the longest film list. Dropped liked and unliked films are still excluded on the server.
+func renderFittingPrompt(version string, data PromptData) (Prompt, error) {
+	budget := promptTokenBudget()
+	for {
+		prompt, err := renderPrompt(version, data)
+		if err != nil {
+			return Prompt{}, err
+		}
+
+		tokens := estimateTokens(prompt.System) + estimateTokens(prompt.User)
+		if tokens <= budget {
+			return prompt, nil
+		}
+
+		lists := []*[]string{&data.LikedFilms, &data.UnlikedFilms, &data.ExcludedFilms}
+		data.Members = slices.Clone(data.Members)
+		for i := range data.Members {
+			lists = append(lists, &data.Members[i].LikedFilms, &data.Members[i].UnlikedFilms)
+		}
+		longest := lists[0]
+		for _, films := range lists {
+			if len(*films) > len(*longest) {
+				longest = films
+			}
+		}
+		if len(*longest) == 0 {
+			return Prompt{}, fmt.Errorf("prompt has %d tokens without any films, the budget is %d", tokens, budget)
+		}
+
+		log.Printf("Prompt %s has about %d tokens, the budget is %d, films are cut", version, tokens, budget)
+		*longest = cutQuarter(*longest)
+	}
+}
+
+func promptTokenBudget() int {
+	budget, err := strconv.Atoi(os.Getenv("PromptTokenBudget"))
+	if err != nil || budget <= 0 {
+		budget = defaultPromptTokenBudget
+	}
+
+	return min(budget, modelContextWindow-reservedResponseTokens)
+}
+
+// estimateTokens counts tokens approximately, a token is about 4 characters of English text
+func estimateTokens(text string) int {
+	return (utf8.RuneCountInString(text) + 3) / 4
+}
+
+// cutQuarter drops the last quarter of films, at least one
+func cutQuarter(films []string) []string {
+	return films[:len(films)-max(len(films)/4, min(len(films), 1))]
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Every user has a queue of films recommended ahead of time in 'recommendation_queue', so that most requests
+// do not wait for ChatGPT and TMDB. Requests take films from the queue and, when fewer than prefetchThreshold
+// are left, send the user id to the 'PrefetchQueueUrl' SQS queue, whose worker fills the queue up to prefetchQueueSize.
+var queueTable = "recommendation_queue"
+var prefetchQueueSize = 20
+var prefetchThreshold = 10
+
+// queuedFilmTtl is how long a film waits in the queue, older films are dropped as the taste may have changed
+var queuedFilmTtl = 7 * 24 * time.Hour
+
+var maxRetries = 3
+
+var sqsClient = sqs.New(sess)
+
+type QueuedFilm struct {
+	Film     RecommendedFilm
+	QueuedAt int64
+}
+
+// PrefetchMessage asks to fill the queue of the user. Profile-changed events of the lambdas changing user films
+// come as messages too, with 'type' 'profile-changed', the change and the films changed.
+type PrefetchMessage struct {
+	Id     string   `json:"id"`
+	Type   string   `json:"type,omitempty"`
+	Change string   `json:"change,omitempty"`
+	Films  []string `json:"films,omitempty"`
+}
+
+// readQueue returns the films of the queue and the stored list, which updates of the queue are conditional on
+func readQueue(userId string) ([]QueuedFilm, *dynamodb.AttributeValue, error) {
+	result, err := db.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(queueTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"id": {
+				S: aws.String(userId),
+			},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("got error calling GetItem: %w", err)
+	}
+	if result.Item == nil || result.Item["films"] == nil {
+		return nil, nil, nil
+	}
+
+	films := make([]QueuedFilm, 0, len(result.Item["films"].L))
+	for _, entry := range result.Item["films"].L {
+		film, ok := parseQueuedFilm(entry)
+		if ok {
+			films = append(films, film)
+		}
+	}
+
+	return films, result.Item["films"], nil
+}
+
+func parseQueuedFilm(entry *dynamodb.AttributeValue) (QueuedFilm, bool) {
+	if entry.M["film"] == nil || entry.M["film"].S == nil || entry.M["queuedAt"] == nil || entry.M["queuedAt"].N == nil {
+		return QueuedFilm{}, false
+	}
+
+	var film QueuedFilm
+	err := json.Unmarshal([]byte(*entry.M["film"].S), &film.Film)
+	if err != nil {
+		log.Printf("Got error parsing queued film, error - %v", err)
+		return QueuedFilm{}, false
+	}
+	film.QueuedAt, err = strconv.ParseInt(*entry.M["queuedAt"].N, 10, 64)
+	if err != nil {
+		return QueuedFilm{}, false
+	}
+
+	return film, true
+}
+
+func toQueueEntries(films []QueuedFilm) ([]*dynamodb.AttributeValue, error) {
+	entries := make([]*dynamodb.AttributeValue, 0, len(films))
+	for _, film := range films {
+		bytes, err := json.Marshal(film.Film)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, &dynamodb.AttributeValue{
+			M: map[string]*dynamodb.AttributeValue{
+				"film": {
+					S: aws.String(string(bytes)),
+				},
+				"queuedAt": {
+					N: aws.String(strconv.FormatInt(film.QueuedAt, 10)),
+				},
+			},
+		})
+	}
+
+	return entries, nil
+}
+
+// writeQueue replaces the films of the queue, if it is still the list read before
+func writeQueue(userId string, films []QueuedFilm, oldFilms *dynamodb.AttributeValue) error {
+	entries, err := toQueueEntries(films)
+	if err != nil {
+		return err
+	}
+
+	conditionExpression := "attribute_not_exists(films)"
+	values := map[string]*dynamodb.AttributeValue{
+		":films": {
+			L: entries,
+		},
+		":updatedAt": {
+			N: aws.String(strconv.FormatInt(time.Now().Unix(), 10)),
+		},
+	}
+	if oldFilms != nil {
+		conditionExpression = "films = :oldFilms"
+		values[":oldFilms"] = oldFilms
+	}
+
+	_, err = db.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(queueTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"id": {
+				S: aws.String(userId),
+			},
+		},
+		ConditionExpression:       aws.String(conditionExpression),
+		UpdateExpression:          aws.String("SET films = :films, updatedAt = :updatedAt"),
+		ExpressionAttributeValues: values,
+	})
+	return err
+}
+
+func isConditionFailed(err error) bool {
+	var awsErr awserr.Error
+	return errors.As(err, &awsErr) && awsErr.Code() == dynamodb.ErrCodeConditionalCheckFailedException
+}
+
+// takeQueuedFilms takes films for the recommendation from the queue. Films which are expired or already shown
+// are dropped, films which do not meet the constraints of this request stay for later requests.
+// It returns the films and how many films are left in the queue.
+func (r Recommendation) takeQueuedFilms() ([]RecommendedFilm, int, error) {
+	for attempts := 0; attempts < maxRetries; attempts++ {
+		queued, oldFilms, err := readQueue(r.UserId)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		expiredBefore := time.Now().Add(-queuedFilmTtl).Unix()
+		var taken []RecommendedFilm
+		var left []QueuedFilm
+		for _, film := range queued {
+			switch {
+			case film.QueuedAt < expiredBefore || r.Shown.contains(film.Film.ResultRecommendedFilm):
+				// dropped
+			case len(taken) < r.PromptData.FilmCount && r.Constraints.mismatch(film.Film.ResultRecommendedFilm) == "":
+				taken = append(taken, film.Film)
+			default:
+				left = append(left, film)
+			}
+		}
+		if len(left) == len(queued) {
+			return nil, len(left), nil
+		}
+
+		err = writeQueue(r.UserId, left, oldFilms)
+		if err == nil {
+			return taken, len(left), nil
+		}
+		if !isConditionFailed(err) {
+			return nil, 0, fmt.Errorf("got error calling UpdateItem: %w", err)
+		}
+	}
+
+	return nil, 0, fmt.Errorf("queue was changed concurrently %d times", maxRetries)
+}
+
+// requestPrefetch asks the worker to fill the queue of the user. Without 'PrefetchQueueUrl' prefetching is off.
+func requestPrefetch(userId string) {
+	queueUrl := os.Getenv("PrefetchQueueUrl")
+	if queueUrl == "" {
+		return
+	}
+
+	body, err := json.Marshal(PrefetchMessage{Id: userId})
+	if err != nil {
+		log.Printf("Got error marshalling prefetch message, user id - %s, error - %v", userId, err)
+		return
+	}
+
+	input := &sqs.SendMessageInput{
+		QueueUrl:    aws.String(queueUrl),
+		MessageBody: aws.String(string(body)),
+	}
+	// a FIFO queue keeps messages of a user in order and drops repeated requests of the user
+	// within its deduplication interval of 5 minutes
+	if strings.HasSuffix(queueUrl, ".fifo") {
+		input.MessageGroupId = aws.String(userId)
+		input.MessageDeduplicationId = aws.String("prefetch-" + userId)
+	}
+
+	_, err = sqsClient.SendMessage(input)
+	if err != nil {
+		log.Printf("Got error requesting prefetch, user id - %s, error - %v", userId, err)
+	}
+}
+
+// recommend takes films from the queue when the request can be served from it and requests the rest.
+// Films of the queue are passed to emit first, as they are ready.
+func (r Recommendation) recommend(ctx context.Context, emit func(film RecommendedFilm)) ([]RecommendedFilm, error) {
+	var films []RecommendedFilm
+	if r.Prefetchable {
+		queued, left, err := r.takeQueuedFilms()
+		if err != nil {
+			log.Printf("Got error taking films from the queue, user id - %s, error - %v", r.UserId, err)
+		}
+		if left < prefetchThreshold {
+			requestPrefetch(r.UserId)
+		}
+
+		for _, film := range queued {
+			r.Shown.add(film.ResultRecommendedFilm)
+			r.ExcludedFilms = append(r.ExcludedFilms, film.Name)
+			if emit != nil {
+				emit(film)
+			}
+		}
+		films = queued
+	}
+	if len(films) >= r.PromptData.FilmCount {
+		return films, nil
+	}
+
+	r.PromptData.FilmCount -= len(films)
+	collected, err := r.collect(ctx, emit)
+	if err != nil && len(films) == 0 {
+		return nil, err
+	}
+	if err != nil {
+		log.Printf("Got error getting film recommendations, queued films are returned, error - %v", err)
+	}
+
+	return append(films, collected...), nil
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/sashabaranov/go-openai"
+	"github.com/sashabaranov/go-openai/jsonschema"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// maxRecommendationAttempts is how many times the model is asked, the first answer included
+var maxRecommendationAttempts = 3
+
+// firstFilmYear is the year of the earliest films, years before it are not real
+var firstFilmYear = 1874
+
+// recommendationSchema is sent as a strict structured output, so every property is required and nothing else is allowed
+var recommendationSchema = jsonschema.Definition{
+	Type: jsonschema.Object,
+	Properties: map[string]jsonschema.Definition{
+		"films": {
+			Type: jsonschema.Array,
+			Items: &jsonschema.Definition{
+				Type: jsonschema.Object,
+				Properties: map[string]jsonschema.Definition{
+					"title": {
+						Type:        jsonschema.String,
+						Description: "Title of the film as it is known on TMDB",
+					},
+					"year": {
+						Type:        jsonschema.Integer,
+						Description: "Release year of the film",
+					},
+					"reason": {
+						Type:        jsonschema.String,
+						Description: "One or two sentences why the film suits the user",
+					},
+					"becauseYouLiked": {
+						Type:        jsonschema.Array,
+						Items:       &jsonschema.Definition{Type: jsonschema.String},
+						Description: "Films the user likes which the film is recommended because of, empty if none",
+					},
+					"memberAffinity": {
+						Type: jsonschema.Array,
+						Items: &jsonschema.Definition{
+							Type: jsonschema.Object,
+							Properties: map[string]jsonschema.Definition{
+								"member": {
+									Type:        jsonschema.String,
+									Description: "Name of the member as in the request, like 'Member 1'",
+								},
+								"affinity": {
+									Type: jsonschema.String,
+									Enum: []string{"high", "medium", "low"},
+								},
+								"hint": {
+									Type:        jsonschema.String,
+									Description: "A few words why the member would like the film or not",
+								},
+							},
+							Required:             []string{"member", "affinity", "hint"},
+							AdditionalProperties: false,
+						},
+						Description: "How much every member of a group would like the film, empty for one person",
+					},
+				},
+				Required:             []string{"title", "year", "reason", "becauseYouLiked", "memberAffinity"},
+				AdditionalProperties: false,
+			},
+		},
+	},
+	Required:             []string{"films"},
+	AdditionalProperties: false,
+}
+
+type FilmRecommendations struct {
+	Films []FilmSuggestion `json:"films"`
+}
+
+// FilmSuggestion is a film as the model recommends it, before it is looked up in TMDB
+type FilmSuggestion struct {
+	Title           string              `json:"title"`
+	Year            int                 `json:"year"`
+	Reason          string              `json:"reason"`
+	BecauseYouLiked []string            `json:"becauseYouLiked"`
+	MemberAffinity  []SuggestedAffinity `json:"memberAffinity"`
+	// TmdbId is known for films chosen from candidates
+	TmdbId int `json:"-"`
+}
+
+type SuggestedAffinity struct {
+	Member   string `json:"member"`
+	Affinity string `json:"affinity"`
+	Hint     string `json:"hint"`
+}
+
+// recommendFilms asks the model for films and validates the answer. When the count is wrong or the answer
+// has excluded, repeated or broken films, the model is told what is wrong and asked again. After the last
+// attempt the valid films of the best answer are returned, an error only when there are none.
+func recommendFilms(ctx context.Context, prompt Prompt, data PromptData, excludedFilms []string) ([]FilmSuggestion, error) {
+	filmCount := data.FilmCount
+	client := openai.NewClient(os.Getenv("OpenAIToken"))
+	messages := []openai.ChatCompletionMessage{
+		{
+			Role:    openai.ChatMessageRoleSystem,
+			Content: prompt.System,
+		},
+		{
+			Role:    openai.ChatMessageRoleUser,
+			Content: prompt.User,
+		},
+	}
+	excluded := make(map[string]bool, len(excludedFilms))
+	for _, film := range excludedFilms {
+		excluded[normalizeTitle(film)] = true
+	}
+	likedFilms := make(map[string]string, len(data.LikedFilms))
+	for _, film := range data.LikedFilms {
+		likedFilms[normalizeTitle(film)] = film
+	}
+	for _, member := range data.Members {
+		for _, film := range member.LikedFilms {
+			likedFilms[normalizeTitle(film)] = film
+		}
+	}
+
+	candidates := make(map[string]bool, len(data.Candidates))
+	for _, film := range data.Candidates {
+		candidates[normalizeTitle(film)] = true
+	}
+
+	var best []FilmSuggestion
+	for attempt := 0; attempt < maxRecommendationAttempts; attempt++ {
+		resp, err := client.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
+			ResponseFormat: &openai.ChatCompletionResponseFormat{
+				Type: openai.ChatCompletionResponseFormatTypeJSONSchema,
+				JSONSchema: &openai.ChatCompletionResponseFormatJSONSchema{
+					Name:   "film_recommendations",
+					Schema: &recommendationSchema,
+					Strict: true,
+				},
+			},
+			Model:    openai.GPT4o,
+			Messages: messages,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("ChatCompletion error: %w", err)
+		}
+		if len(resp.Choices) == 0 {
+			log.Printf("ChatGPT returned no choices, attempt %d", attempt+1)
+			continue
+		}
+
+		content := resp.Choices[0].Message.Content
+		var recommendations FilmRecommendations
+		err = jsonschema.VerifySchemaAndUnmarshal(recommendationSchema, []byte(content), &recommendations)
+		if err != nil {
+			log.Printf("ChatGPT response does not match the schema, attempt %d, error - %v, response - %s", attempt+1, err, content)
+			messages = append(messages, assistantMessage(content), correctionMessage(filmCount, []string{"the answer does not match the required format"}))
+			continue
+		}
+
+		films, problems := validateSuggestions(recommendations.Films, excluded, likedFilms, candidates)
+		if len(films) > filmCount {
+			films = films[:filmCount]
+		}
+		if len(films) > len(best) {
+			best = films
+		}
+		if len(films) == filmCount {
+			return films, nil
+		}
+
+		problems = append(problems, fmt.Sprintf("there are %d suitable films instead of %d", len(films), filmCount))
+		log.Printf("ChatGPT response is not correct, attempt %d, problems - %v", attempt+1, problems)
+		messages = append(messages, assistantMessage(content), correctionMessage(filmCount, problems))
+	}
+
+	if len(best) == 0 {
+		return nil, fmt.Errorf("no valid film recommendations after %d attempts", maxRecommendationAttempts)
+	}
+	return best, nil
+}
+
+// validateSuggestions keeps films with a title and a real year, which are neither excluded nor repeated,
+// and which are candidates when there are any. Liked films of the explanation are replaced by their titles
+// as the user rated them, unknown ones are dropped.
+func validateSuggestions(suggestions []FilmSuggestion, excluded map[string]bool, likedFilms map[string]string, candidates map[string]bool) ([]FilmSuggestion, []string) {
+	films := make([]FilmSuggestion, 0, len(suggestions))
+	problems := []string{}
+	seen := map[string]bool{}
+	maxYear := time.Now().Year() + 1
+	for _, suggestion := range suggestions {
+		suggestion.Title = strings.TrimSpace(suggestion.Title)
+		title := normalizeTitle(suggestion.Title)
+		switch {
+		case title == "":
+			problems = append(problems, "a film has no title")
+		case suggestion.Year < firstFilmYear || suggestion.Year > maxYear:
+			problems = append(problems, fmt.Sprintf("'%s' has a wrong year %d", suggestion.Title, suggestion.Year))
+		case excluded[title]:
+			problems = append(problems, fmt.Sprintf("'%s' is one of the films to exclude", suggestion.Title))
+		case len(candidates) > 0 && !candidates[title] && !candidates[title+strconv.Itoa(suggestion.Year)]:
+			problems = append(problems, fmt.Sprintf("'%s' is not one of the candidates", suggestion.Title))
+		case seen[title]:
+			problems = append(problems, fmt.Sprintf("'%s' is recommended twice", suggestion.Title))
+		default:
+			seen[title] = true
+			suggestion.BecauseYouLiked = knownLikedFilms(suggestion.BecauseYouLiked, likedFilms)
+			films = append(films, suggestion)
+		}
+	}
+
+	return films, problems
+}
+
+func knownLikedFilms(films []string, likedFilms map[string]string) []string {
+	known := make([]string, 0, len(films))
+	for _, film := range films {
+		if likedFilm, ok := likedFilms[normalizeTitle(film)]; ok && !slices.Contains(known, likedFilm) {
+			known = append(known, likedFilm)
+		}
+	}
+
+	return known
+}
+
+// normalizeTitle makes titles comparable regardless of case, punctuation and spacing
+func normalizeTitle(title string) string {
+	var normalized strings.Builder
+	for _, r := range strings.ToLower(title) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			normalized.WriteRune(r)
+		}
+	}
+
+	return normalized.String()
+}
+
+func assistantMessage(content string) openai.ChatCompletionMessage {
+	return openai.ChatCompletionMessage{
+		Role:    openai.ChatMessageRoleAssistant,
+		Content: content,
+	}
+}
+
+func correctionMessage(filmCount int, problems []string) openai.ChatCompletionMessage {
+	return openai.ChatCompletionMessage{
+		Role: openai.ChatMessageRoleUser,
+		Content: "Your answer is not correct: " + strings.Join(problems, "; ") + ". " +
+			fmt.Sprintf("Answer again with exactly %d films, none of them mentioned before in my messages.", filmCount),
+	}
+}
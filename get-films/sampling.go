@@ -0,0 +1,69 @@
+package main
+
+import (
+	"cmp"
+	"finder/common/tmdb"
+	"slices"
+)
+
+// historySamplings are the ways to pick films of a long history for the prompt:
+// 'recent' takes the latest rated films, 'genres' takes films of every genre the user rated, the latest first
+var historySamplings = []string{"recent", "genres"}
+
+// Most liked and unliked films put in the prompt, the prompt is cut further when it does not fit its token budget
+var maxPromptLikedFilms = 50
+var maxPromptUnlikedFilms = 25
+
+// maxSampledFilms is how many of the latest films at most are looked up in TMDB to be sampled by genres
+var maxSampledFilms = 200
+
+// sampleHistory cuts liked and unliked films of the prompt. Lists are stored newest first.
+func sampleHistory(data PromptData, sampling string) PromptData {
+	if sampling == "genres" {
+		data.LikedFilms = sampleByGenres(data.LikedFilms, maxPromptLikedFilms)
+		data.UnlikedFilms = sampleByGenres(data.UnlikedFilms, maxPromptUnlikedFilms)
+		return data
+	}
+
+	data.LikedFilms = data.LikedFilms[:min(len(data.LikedFilms), maxPromptLikedFilms)]
+	data.UnlikedFilms = data.UnlikedFilms[:min(len(data.UnlikedFilms), maxPromptUnlikedFilms)]
+	return data
+}
+
+// sampleByGenres groups films by their main genre in TMDB and takes films from every group in turn,
+// bigger groups first, so that the prompt shows the whole taste of the user and not only the latest of it.
+// Only the latest maxSampledFilms films are sampled, so a long history does not hold up recommendations.
+func sampleByGenres(films []string, count int) []string {
+	if len(films) <= count {
+		return films
+	}
+
+	films = films[:min(len(films), maxSampledFilms)]
+	details := tmdb.GetFilmsDetails(films)
+	var genres []string
+	groups := map[string][]string{}
+	for _, film := range films {
+		genre := ""
+		if detail, ok := details[film]; ok && len(detail.Genres) > 0 {
+			genre = detail.Genres[0]
+		}
+		if _, ok := groups[genre]; !ok {
+			genres = append(genres, genre)
+		}
+		groups[genre] = append(groups[genre], film)
+	}
+	slices.SortStableFunc(genres, func(a, b string) int {
+		return cmp.Compare(len(groups[b]), len(groups[a]))
+	})
+
+	sampled := make([]string, 0, count)
+	for index := 0; len(sampled) < count; index++ {
+		for _, genre := range genres {
+			if index < len(groups[genre]) && len(sampled) < count {
+				sampled = append(sampled, groups[genre][index])
+			}
+		}
+	}
+
+	return sampled
+}
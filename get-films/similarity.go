@@ -0,0 +1,337 @@
+package main
+
+import (
+	"cmp"
+	"finder/common/tmdb"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Films liked by the same users are computed by the film-similarity job into 'film_similarity'. The recommender
+// picks how they are used: 'llm' does not use them, 'collaborative' recommends the films most similar to the liked
+// films without the model, 'rerank' gives them to the model as candidates to choose from, 'hybrid' gives them
+// together with films TMDB recommends for the liked films.
+var recommenders = []string{"llm", "collaborative", "rerank", "hybrid"}
+var defaultRecommender = "llm"
+
+var similarityTable = "film_similarity"
+
+// maxSimilaritySeeds is how many of the latest liked films of every member the similar films are read for,
+// at most 100 films in total, as many as one BatchGetItem reads
+var maxSimilaritySeeds = 25
+var maxSimilarityKeys = 100
+
+// maxRerankCandidates is the most candidates put in the prompt
+var maxRerankCandidates = 30
+
+// maxCandidateReasons is the most liked films a collaborative film is explained by
+var maxCandidateReasons = 3
+
+// collaborativeVersion is the prompt version of films recommended without the model
+var collaborativeVersion = "collaborative"
+
+// Candidate is a film liked by users who liked the films of the user. Score is the sum of its similarities
+// to the liked films, BecauseYouLiked are the liked films it is similar to, the most similar first.
+type Candidate struct {
+	Title           string
+	Year            string
+	TmdbId          int
+	Score           float64
+	BecauseYouLiked []string
+	// similarities of the liked films the candidate is similar to, by their titles
+	similarTo map[string]float64
+}
+
+// getRecommender picks the recommender from 'recommender' param, then from 'Recommender' environment variable
+func getRecommender(requested string) (string, error) {
+	recommender := requested
+	if recommender == "" {
+		recommender = os.Getenv("Recommender")
+	}
+	if recommender == "" {
+		recommender = defaultRecommender
+	}
+
+	if !slices.Contains(recommenders, recommender) {
+		return "", fmt.Errorf("expected 'llm', 'collaborative', 'rerank' or 'hybrid', recommender - %s", recommender)
+	}
+	return recommender, nil
+}
+
+// similarCandidates reads similar films of the latest liked films of the members, the best candidates first
+func similarCandidates(members []Member) ([]Candidate, error) {
+	seeds := map[string]string{}
+	keys := make([]map[string]*dynamodb.AttributeValue, 0, maxSimilarityKeys)
+	for _, title := range latestLikedFilms(members, maxSimilaritySeeds, maxSimilarityKeys) {
+		key := normalizeTitle(title)
+		seeds[key] = title
+		keys = append(keys, map[string]*dynamodb.AttributeValue{"film": {S: aws.String(key)}})
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	items, err := batchGetSimilarities(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := map[string]*Candidate{}
+	for _, item := range items {
+		if item["film"] == nil || item["film"].S == nil || item["similar"] == nil {
+			continue
+		}
+		seed := seeds[*item["film"].S]
+		for _, entry := range item["similar"].L {
+			title := filmTitle(entry)
+			key := normalizeTitle(title)
+			if key == "" || seeds[key] != "" {
+				continue
+			}
+
+			candidate, ok := candidates[key]
+			if !ok {
+				candidate = &Candidate{Title: title, similarTo: map[string]float64{}}
+				candidates[key] = candidate
+			}
+			if candidate.TmdbId == 0 && entry.M["tmdbId"] != nil && entry.M["tmdbId"].N != nil {
+				candidate.TmdbId, _ = strconv.Atoi(*entry.M["tmdbId"].N)
+			}
+			if entry.M["score"] != nil && entry.M["score"].N != nil {
+				score, _ := strconv.ParseFloat(*entry.M["score"].N, 64)
+				candidate.Score += score
+				candidate.similarTo[seed] = score
+			}
+		}
+	}
+
+	return sortedCandidates(candidates), nil
+}
+
+// latestLikedFilms takes the latest liked films of every member, without repeats
+func latestLikedFilms(members []Member, perMember int, total int) []string {
+	var films []string
+	seen := map[string]bool{}
+	for _, member := range members {
+		if member.Item["likedFilms"] == nil {
+			continue
+		}
+		count := 0
+		for _, entry := range member.Item["likedFilms"].L {
+			if count == perMember || len(films) == total {
+				break
+			}
+			title := filmTitle(entry)
+			key := normalizeTitle(title)
+			if key == "" || seen[key] {
+				continue
+			}
+			seen[key] = true
+			films = append(films, title)
+			count++
+		}
+	}
+
+	return films
+}
+
+// sortedCandidates explains every candidate by the liked films it is most similar to and puts the best first
+func sortedCandidates(candidates map[string]*Candidate) []Candidate {
+	sorted := make([]Candidate, 0, len(candidates))
+	for _, candidate := range candidates {
+		for seed := range candidate.similarTo {
+			candidate.BecauseYouLiked = append(candidate.BecauseYouLiked, seed)
+		}
+		slices.SortFunc(candidate.BecauseYouLiked, func(a, b string) int {
+			return cmp.Compare(candidate.similarTo[b], candidate.similarTo[a])
+		})
+		sorted = append(sorted, *candidate)
+	}
+	sortCandidates(sorted)
+
+	return sorted
+}
+
+// gatherCandidates reads the candidates of the recommender. Similar films which cannot be read fail only
+// 'collaborative', the other recommenders go on without them.
+func (r Recommendation) gatherCandidates() ([]Candidate, error) {
+	if r.Recommender == "llm" {
+		return nil, nil
+	}
+
+	similar, err := similarCandidates(r.Members)
+	if err != nil && r.Recommender == "collaborative" {
+		return nil, err
+	}
+	if err != nil {
+		log.Printf("Got error reading similar films, recommender - %s, error - %v", r.Recommender, err)
+	}
+	if r.Recommender != "hybrid" {
+		return similar, nil
+	}
+
+	return withTmdbIds(mergeCandidates(tmdbCandidates(r.Members), similar)), nil
+}
+
+// withTmdbIds leaves out candidates never found in TMDB, as films of 'hybrid' are got by the id of the candidate
+func withTmdbIds(candidates []Candidate) []Candidate {
+	found := make([]Candidate, 0, len(candidates))
+	for _, candidate := range candidates {
+		if candidate.TmdbId != 0 {
+			found = append(found, candidate)
+		}
+	}
+	if left := len(candidates) - len(found); left > 0 {
+		log.Printf("Left out %d candidates without TMDB id", left)
+	}
+
+	return found
+}
+
+// batchGetSimilarities reads the items of the films, unprocessed keys are read again with a growing pause
+func batchGetSimilarities(keys []map[string]*dynamodb.AttributeValue) ([]map[string]*dynamodb.AttributeValue, error) {
+	var items []map[string]*dynamodb.AttributeValue
+	pending := map[string]*dynamodb.KeysAndAttributes{
+		similarityTable: {
+			Keys: keys,
+		},
+	}
+	for attempts := 0; attempts < maxRetries; attempts++ {
+		if attempts > 0 {
+			time.Sleep(time.Duration(100<<attempts) * time.Millisecond)
+		}
+
+		output, err := db.BatchGetItem(&dynamodb.BatchGetItemInput{
+			RequestItems: pending,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("got error calling BatchGetItem: %w", err)
+		}
+		items = append(items, output.Responses[similarityTable]...)
+		if output.UnprocessedKeys[similarityTable] == nil || len(output.UnprocessedKeys[similarityTable].Keys) == 0 {
+			return items, nil
+		}
+		pending = output.UnprocessedKeys
+	}
+
+	log.Printf("Similar films of %d liked films are not read after %d attempts", len(pending[similarityTable].Keys), maxRetries)
+	return items, nil
+}
+
+// freshCandidates are the candidates which are neither excluded nor shown to the user yet
+func (r Recommendation) freshCandidates(excludedFilms []string) []Candidate {
+	excluded := make(map[string]bool, len(excludedFilms))
+	for _, film := range excludedFilms {
+		excluded[normalizeTitle(film)] = true
+	}
+
+	fresh := make([]Candidate, 0, len(r.Candidates))
+	for _, candidate := range r.Candidates {
+		if excluded[normalizeTitle(candidate.Title)] || r.Shown.contains(tmdb.ResultRecommendedFilm{ID: candidate.TmdbId, Name: candidate.Title}) {
+			continue
+		}
+		fresh = append(fresh, candidate)
+	}
+
+	return fresh
+}
+
+// collaborativeSuggestions recommends the best candidates, explained by the liked films they are similar to
+func collaborativeSuggestions(candidates []Candidate, filmCount int) []FilmSuggestion {
+	suggestions := make([]FilmSuggestion, 0, filmCount)
+	for _, candidate := range candidates[:min(len(candidates), filmCount)] {
+		becauseYouLiked := candidate.BecauseYouLiked[:min(len(candidate.BecauseYouLiked), maxCandidateReasons)]
+		suggestions = append(suggestions, FilmSuggestion{
+			Title:           candidate.Title,
+			TmdbId:          candidate.TmdbId,
+			Reason:          "Liked by people who liked " + strings.Join(becauseYouLiked, ", "),
+			BecauseYouLiked: becauseYouLiked,
+		})
+	}
+
+	return suggestions
+}
+
+// candidateTitles are the candidates as they are put in the prompt, with the release year when it is known
+func candidateTitles(candidates []Candidate) []string {
+	titles := make([]string, 0, len(candidates))
+	for _, candidate := range candidates {
+		if candidate.Year != "" {
+			titles = append(titles, candidate.Title+" ("+candidate.Year+")")
+			continue
+		}
+		titles = append(titles, candidate.Title)
+	}
+
+	return titles
+}
+
+// matchCandidates sets TMDB ids of the candidates the model chose, so that they are got by the id and not searched.
+// The model may answer with the year in the title, as the candidates are written in the prompt.
+func matchCandidates(suggestions []FilmSuggestion, candidates []Candidate) {
+	index := make(map[string]Candidate, 2*len(candidates))
+	for _, candidate := range candidates {
+		index[normalizeTitle(candidate.Title)] = candidate
+		index[normalizeTitle(candidate.Title)+candidate.Year] = candidate
+	}
+
+	for i, suggestion := range suggestions {
+		title := normalizeTitle(suggestion.Title)
+		candidate, ok := index[title+strconv.Itoa(suggestion.Year)]
+		if !ok {
+			candidate, ok = index[title]
+		}
+		if ok {
+			suggestions[i].Title = candidate.Title
+			suggestions[i].TmdbId = candidate.TmdbId
+		}
+	}
+}
+
+// mergeCandidates joins candidates of several sources by title, scores of the same film are added up
+func mergeCandidates(sources ...[]Candidate) []Candidate {
+	var merged []Candidate
+	positions := map[string]int{}
+	for _, candidates := range sources {
+		for _, candidate := range candidates {
+			key := normalizeTitle(candidate.Title)
+			position, ok := positions[key]
+			if !ok {
+				positions[key] = len(merged)
+				merged = append(merged, candidate)
+				continue
+			}
+
+			existing := &merged[position]
+			existing.Score += candidate.Score
+			if existing.TmdbId == 0 {
+				existing.TmdbId = candidate.TmdbId
+				existing.Year = candidate.Year
+			}
+			for _, film := range candidate.BecauseYouLiked {
+				if !slices.Contains(existing.BecauseYouLiked, film) {
+					existing.BecauseYouLiked = append(existing.BecauseYouLiked, film)
+				}
+			}
+		}
+	}
+
+	sortCandidates(merged)
+	return merged
+}
+
+func sortCandidates(candidates []Candidate) {
+	slices.SortFunc(candidates, func(a, b Candidate) int {
+		if a.Score != b.Score {
+			return cmp.Compare(b.Score, a.Score)
+		}
+		return cmp.Compare(a.Title, b.Title)
+	})
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMergeCandidates(t *testing.T) {
+	tests := []struct {
+		name    string
+		sources [][]Candidate
+		want    []Candidate
+	}{
+		{
+			name:    "no sources",
+			sources: nil,
+			want:    nil,
+		},
+		{
+			name: "one source is sorted",
+			sources: [][]Candidate{{
+				{Title: "Up", Score: 0.5},
+				{Title: "Heat", Score: 0.9},
+				{Title: "Alien", Score: 0.5},
+			}},
+			want: []Candidate{
+				{Title: "Heat", Score: 0.9},
+				{Title: "Alien", Score: 0.5},
+				{Title: "Up", Score: 0.5},
+			},
+		},
+		{
+			name: "same film of several sources",
+			sources: [][]Candidate{
+				{
+					{Title: "Heat", Score: 0.4, BecauseYouLiked: []string{"Thief"}},
+					{Title: "Alien", Score: 0.6, BecauseYouLiked: []string{"Aliens"}},
+				},
+				{
+					{Title: "HEAT!", Year: "1995", TmdbId: 949, Score: 0.5, BecauseYouLiked: []string{"Thief", "Ronin"}},
+				},
+			},
+			want: []Candidate{
+				{Title: "Heat", Year: "1995", TmdbId: 949, Score: 0.9, BecauseYouLiked: []string{"Thief", "Ronin"}},
+				{Title: "Alien", Score: 0.6, BecauseYouLiked: []string{"Aliens"}},
+			},
+		},
+		{
+			name: "TMDB id of the first source is kept",
+			sources: [][]Candidate{
+				{{Title: "Heat", Year: "1995", TmdbId: 949, Score: 0.5}},
+				{{Title: "Heat", Year: "1986", TmdbId: 42, Score: 0.25}},
+			},
+			want: []Candidate{
+				{Title: "Heat", Year: "1995", TmdbId: 949, Score: 0.75},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if merged := mergeCandidates(test.sources...); !reflect.DeepEqual(merged, test.want) {
+				t.Errorf("mergeCandidates returned %+v, expected %+v", merged, test.want)
+			}
+		})
+	}
+}
+
+func TestWithTmdbIds(t *testing.T) {
+	merged := mergeCandidates(
+		[]Candidate{{Title: "Heat", Year: "1995", TmdbId: 949, Score: 0.5}},
+		[]Candidate{{Title: "Heat", Score: 0.25}, {Title: "Thief", Score: 0.5}},
+	)
+
+	want := []Candidate{{Title: "Heat", Year: "1995", TmdbId: 949, Score: 0.75}}
+	if candidates := withTmdbIds(merged); !reflect.DeepEqual(candidates, want) {
+		t.Errorf("withTmdbIds returned %+v, expected %+v", candidates, want)
+	}
+}
+
+func TestMatchCandidates(t *testing.T) {
+	candidates := []Candidate{
+		{Title: "Heat", Year: "1995", TmdbId: 949},
+		{Title: "Heat", Year: "1986", TmdbId: 42},
+		{Title: "Amélie", Year: "2001", TmdbId: 194},
+	}
+
+	tests := []struct {
+		name       string
+		suggestion FilmSuggestion
+		want       FilmSuggestion
+	}{
+		{
+			name:       "title and year",
+			suggestion: FilmSuggestion{Title: "Heat", Year: 1995},
+			want:       FilmSuggestion{Title: "Heat", Year: 1995, TmdbId: 949},
+		},
+		{
+			name:       "same title of another year",
+			suggestion: FilmSuggestion{Title: "heat", Year: 1986},
+			want:       FilmSuggestion{Title: "Heat", Year: 1986, TmdbId: 42},
+		},
+		{
+			name:       "title without year",
+			suggestion: FilmSuggestion{Title: "amélie!"},
+			want:       FilmSuggestion{Title: "Amélie", TmdbId: 194},
+		},
+		{
+			name:       "year unknown to candidates",
+			suggestion: FilmSuggestion{Title: "Amélie", Year: 2002},
+			want:       FilmSuggestion{Title: "Amélie", Year: 2002, TmdbId: 194},
+		},
+		{
+			name:       "not a candidate",
+			suggestion: FilmSuggestion{Title: "Alien", Year: 1979, Reason: "space"},
+			want:       FilmSuggestion{Title: "Alien", Year: 1979, Reason: "space"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			suggestions := []FilmSuggestion{test.suggestion}
+			matchCandidates(suggestions, candidates)
+			if !reflect.DeepEqual(suggestions[0], test.want) {
+				t.Errorf("matchCandidates made %+v, expected %+v", suggestions[0], test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-lambda-go/events"
+	"io"
+	"log"
+	"net/url"
+	"strings"
+)
+
+// Streamed films are written one event per film as soon as the film is looked up in TMDB, then 'done' with
+// the count of films, or 'error' when the recommendation failed. The 'stream' param picks the format:
+// 'ndjson' (default) writes '{"event": ..., "data": ...}' lines, 'sse' writes Server-Sent Events.
+var streamFormats = map[string]string{
+	"ndjson": "application/x-ndjson",
+	"sse":    "text/event-stream",
+}
+
+func handleStreamingRequest(ctx context.Context, req events.LambdaFunctionURLRequest) (*events.LambdaFunctionURLStreamingResponse, error) {
+	proxyRequest := toProxyRequest(req.RawQueryString)
+	format, errorResponse := getStreamFormat(proxyRequest, "ndjson")
+	if errorResponse != nil {
+		return toStreamingResponse(errorResponse), nil
+	}
+
+	recommendation, errorResponse := prepareRecommendation(proxyRequest)
+	if errorResponse != nil {
+		return toStreamingResponse(errorResponse), nil
+	}
+
+	reader, writer := io.Pipe()
+	go func() {
+		streamRecommendations(ctx, recommendation, format, writer, func() {})
+		writer.Close()
+	}()
+
+	return &events.LambdaFunctionURLStreamingResponse{
+		StatusCode: 200,
+		Headers: map[string]string{
+			"Content-Type":  streamFormats[format],
+			"Cache-Control": "no-cache",
+		},
+		Body: reader,
+	}, nil
+}
+
+func toStreamingResponse(response *events.APIGatewayProxyResponse) *events.LambdaFunctionURLStreamingResponse {
+	return &events.LambdaFunctionURLStreamingResponse{
+		StatusCode: response.StatusCode,
+		Body:       strings.NewReader(response.Body),
+	}
+}
+
+// toProxyRequest reads the query string the way API Gateway does, so that params are parsed the same in every mode
+func toProxyRequest(rawQuery string) events.APIGatewayProxyRequest {
+	values, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		log.Printf("Got error parsing query string, some params are skipped: %v", err)
+	}
+
+	req := events.APIGatewayProxyRequest{
+		QueryStringParameters:           make(map[string]string, len(values)),
+		MultiValueQueryStringParameters: values,
+	}
+	for name, value := range values {
+		req.QueryStringParameters[name] = value[len(value)-1]
+	}
+
+	return req
+}
+
+// getStreamFormat reads 'stream' param, an empty format means the response is not streamed
+func getStreamFormat(req events.APIGatewayProxyRequest, defaultFormat string) (string, *events.APIGatewayProxyResponse) {
+	format := req.QueryStringParameters["stream"]
+	if format == "" {
+		format = defaultFormat
+	}
+	if _, ok := streamFormats[format]; !ok && format != "" {
+		log.Printf("Provided stream format is not correct, stream - %s", format)
+		return "", &events.APIGatewayProxyResponse{
+			StatusCode: 400,
+			Body:       "Provided stream format is not correct, expected 'ndjson' or 'sse', stream - " + format,
+		}
+	}
+
+	return format, nil
+}
+
+// streamRecommendations writes films while they are found. Writing stops at the first error, as the client is gone,
+// but the films found are still recorded in the history.
+func streamRecommendations(ctx context.Context, recommendation Recommendation, format string, writer io.Writer, flush func()) {
+	var writeErr error
+	write := func(event string, data any) {
+		if writeErr != nil {
+			return
+		}
+		writeErr = writeEvent(writer, format, event, data)
+		if writeErr != nil {
+			log.Printf("Got error writing %s event, user id - %s, error - %v", event, recommendation.UserId, writeErr)
+			return
+		}
+		flush()
+	}
+
+	films, err := recommendation.recommend(ctx, func(film RecommendedFilm) {
+		write("film", film)
+	})
+	if err != nil {
+		log.Printf("Got error getting film recommendations: %v", err)
+		write("error", map[string]string{"error": "Got error getting film recommendations: " + err.Error()})
+		return
+	}
+
+	recommendation.record(films)
+	write("done", map[string]int{"count": len(films)})
+}
+
+func writeEvent(writer io.Writer, format string, event string, data any) error {
+	if format == "sse" {
+		bytes, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(writer, "event: %s\ndata: %s\n\n", event, bytes)
+		return err
+	}
+
+	bytes, err := json.Marshal(map[string]any{"event": event, "data": data})
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(append(bytes, '\n'))
+	return err
+}
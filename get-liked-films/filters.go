@@ -0,0 +1,129 @@
+package main
+
+import (
+	"finder/common/tmdb"
+	"fmt"
+	"github.com/aws/aws-lambda-go/events"
+	"strconv"
+	"strings"
+)
+
+// Filters narrow down liked films. Title search works on the stored names, the rest is evaluated
+// against TMDB details of the films, so films not found in TMDB never match them.
+type Filters struct {
+	Title    string
+	Genre    string
+	Director string
+	Decade   int
+	Language string
+}
+
+// getFilters reads 'q', 'genre', 'director', 'decade' (1990 or 1990s) and 'language' (ISO 639-1, e.g. 'fr') params
+func getFilters(req events.APIGatewayProxyRequest) (Filters, error) {
+	filters := Filters{
+		Title:    strings.ToLower(strings.TrimSpace(req.QueryStringParameters["q"])),
+		Genre:    strings.ToLower(strings.TrimSpace(req.QueryStringParameters["genre"])),
+		Director: strings.ToLower(strings.TrimSpace(req.QueryStringParameters["director"])),
+		Language: strings.ToLower(strings.TrimSpace(req.QueryStringParameters["language"])),
+	}
+
+	if decadeString := req.QueryStringParameters["decade"]; decadeString != "" {
+		decade, err := strconv.Atoi(strings.TrimSuffix(decadeString, "s"))
+		if err != nil || decade <= 0 || decade%10 != 0 {
+			return Filters{}, fmt.Errorf("decade must be a year ending with 0, like 1990 or 1990s, decade - %s", decadeString)
+		}
+		filters.Decade = decade
+	}
+
+	return filters, nil
+}
+
+func (f Filters) needsDetails() bool {
+	return f.Genre != "" || f.Director != "" || f.Decade != 0 || f.Language != ""
+}
+
+// maxDetailLookups is how many films at most are looked up in TMDB for one page with TMDB filters
+var maxDetailLookups = 200
+
+// filterFilms filters by the title, TMDB filters are applied page by page by filterPage
+func filterFilms(likedFilms []LikedFilm, filters Filters) []LikedFilm {
+	if filters.Title == "" {
+		return likedFilms
+	}
+
+	var filtered []LikedFilm
+	for _, film := range likedFilms {
+		if strings.Contains(strings.ToLower(film.Name), filters.Title) {
+			filtered = append(filtered, film)
+		}
+	}
+
+	return filtered
+}
+
+// filterPage takes a page of already sorted films matching TMDB filters. Films after the cursor are looked up
+// a page size at a time until the page is full or maxDetailLookups films are looked up, so a long list does not
+// cost thousands of lookups. The cursor points at the last looked up film, the next page goes on from there.
+func filterPage(likedFilms []LikedFilm, filters Filters, pagination Pagination) ([]LikedFilm, string) {
+	paginated := make([]LikedFilm, 0)
+	start := pageStart(likedFilms, pagination)
+	limit := min(len(likedFilms), start+maxDetailLookups)
+
+	next := start
+	for next < limit && len(paginated) < pagination.Size {
+		chunk := likedFilms[next:min(next+pagination.Size, limit)]
+		filmNames := make([]string, 0, len(chunk))
+		for _, film := range chunk {
+			filmNames = append(filmNames, film.Name)
+		}
+		details := tmdb.GetFilmsDetails(filmNames)
+
+		for _, film := range chunk {
+			next++
+			if filmDetails, ok := details[film.Name]; ok && filters.matches(filmDetails) {
+				paginated = append(paginated, film)
+			}
+			if len(paginated) == pagination.Size {
+				break
+			}
+		}
+	}
+
+	if next >= len(likedFilms) {
+		return paginated, ""
+	}
+
+	return paginated, encodeCursor(newCursor(likedFilms[next-1], pagination.Sort))
+}
+
+func (f Filters) matches(film tmdb.ResultRecommendedFilm) bool {
+	if f.Genre != "" && !containsFold(film.Genres, f.Genre, false) {
+		return false
+	}
+	if f.Director != "" && !containsFold(film.DirectedBy, f.Director, true) {
+		return false
+	}
+	if f.Language != "" && strings.ToLower(film.OriginalLanguage) != f.Language {
+		return false
+	}
+	if f.Decade != 0 {
+		year, err := strconv.Atoi(film.Year)
+		if err != nil || year < f.Decade || year >= f.Decade+10 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// containsFold checks values case-insensitively, either for an equal value or for a value containing the given one
+func containsFold(values []string, value string, substring bool) bool {
+	for _, v := range values {
+		v = strings.ToLower(v)
+		if v == value || (substring && strings.Contains(v, value)) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"finder/common/tmdb"
 	"fmt"
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
@@ -11,9 +12,8 @@ import (
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/google/uuid"
 	"log"
-	"slices"
-	"sort"
 	"strconv"
+	"time"
 )
 
 var sess = session.Must(session.NewSession())
@@ -27,11 +27,11 @@ func handleRequest(ctx context.Context, req events.APIGatewayProxyRequest) (even
 	userId, err := getUserIdAndVerify(req)
 	if err != nil {
 		id := req.QueryStringParameters["id"]
-		log.Fatalf("Provided user id is not correct, user id - %s", id)
+		log.Printf("Provided user id is not correct, user id - %s", id)
 		return events.APIGatewayProxyResponse{
 			StatusCode: 400,
 			Body:       "Provided user id is not correct, user id - " + id,
-		}, err
+		}, nil
 	}
 
 	//retrieving user info from DynamoDB
@@ -44,47 +44,77 @@ func handleRequest(ctx context.Context, req events.APIGatewayProxyRequest) (even
 		},
 	})
 	if err != nil {
-		log.Fatalf("Got error calling GetItem: %s", err)
+		log.Printf("Got error calling GetItem: %s", err)
 		return events.APIGatewayProxyResponse{
 			StatusCode: 500,
 			Body:       "Got error calling GetItem: " + err.Error(),
-		}, err
-	}
-
-	//preparing pagination params. If no 'size' in query params, then size is totalCount
-	totalCount := len(result.Item["likedFilms"].L)
-	size := totalCount
-	var page int
-	sizeString := req.QueryStringParameters["size"]
-	pageString := req.QueryStringParameters["page"]
-	if sizeString != "" && pageString != "" {
-		size, err = strconv.Atoi(sizeString)
-		page, err = strconv.Atoi(pageString)
-
-		if err != nil {
-			log.Fatalf("Pagination params are not correct. Size - %s, Page - %s", sizeString, pageString)
-			return events.APIGatewayProxyResponse{
-				StatusCode: 500,
-				Body:       fmt.Sprintf("Pagination params are not correct. Size - %s, Page - %s, Error - %v", sizeString, pageString, err.Error()),
-			}, err
-		}
+		}, nil
+	}
+
+	from, to, err := getDateRange(req)
+	if err != nil {
+		log.Printf("Date range params are not correct: %v", err)
+		return events.APIGatewayProxyResponse{
+			StatusCode: 400,
+			Body:       "Date range params are not correct: " + err.Error(),
+		}, nil
+	}
+	filters, err := getFilters(req)
+	if err != nil {
+		log.Printf("Filter params are not correct: %v", err)
+		return events.APIGatewayProxyResponse{
+			StatusCode: 400,
+			Body:       "Filter params are not correct: " + err.Error(),
+		}, nil
+	}
+	//a user without an item or without liked films has an empty list
+	var entries []*dynamodb.AttributeValue
+	if likedFilms := result.Item["likedFilms"]; likedFilms != nil {
+		entries = likedFilms.L
 	}
+	allLikedFilms := filterFilmsByDate(toLikedFilms(entries), from, to)
+	allLikedFilms = filterFilms(allLikedFilms, filters)
 
-	likedFilms := paginateFilms(result.Item["likedFilms"].L, page, size)
-	likedFilms = sortFilms(likedFilms, req.QueryStringParameters["sort"])
+	pagination, err := getPagination(req)
+	if err != nil {
+		log.Printf("Pagination params are not correct: %v", err)
+		return events.APIGatewayProxyResponse{
+			StatusCode: 400,
+			Body:       "Pagination params are not correct: " + err.Error(),
+		}, nil
+	}
+	if filters.needsDetails() && pagination.Page != 0 {
+		log.Printf("Page is used with TMDB filters, page - %d", pagination.Page)
+		return events.APIGatewayProxyResponse{
+			StatusCode: 400,
+			Body:       "Pagination params are not correct: page can not be used with 'genre', 'director', 'decade' and 'language', use cursor",
+		}, nil
+	}
+
+	//sorting the whole list before paging, so that every page continues the previous one
+	allLikedFilms = sortFilms(allLikedFilms, pagination.Sort)
 	pageableResult := PageableResult{
-		Page:       page,
-		TotalCount: totalCount,
-		Content:    likedFilms,
+		Page: pagination.Page,
+		Size: pagination.Size,
+	}
+	if filters.needsDetails() {
+		pageableResult.Content, pageableResult.NextCursor = filterPage(allLikedFilms, filters, pagination)
+	} else {
+		totalCount := len(allLikedFilms)
+		pageableResult.TotalCount = &totalCount
+		pageableResult.Content, pageableResult.NextCursor = paginateFilms(allLikedFilms, pagination)
+	}
+	if req.QueryStringParameters["expand"] == "details" {
+		pageableResult.Content = expandFilms(pageableResult.Content)
 	}
 
 	jsonArray, err := json.Marshal(pageableResult)
 	if err != nil {
-		log.Fatalf("Got error parsing to result JSON: %v", pageableResult)
+		log.Printf("Got error parsing to result JSON: %v", err)
 		return events.APIGatewayProxyResponse{
 			StatusCode: 500,
 			Body:       "Got error parsing string array to result JSON: " + err.Error(),
-		}, err
+		}, nil
 	}
 
 	return events.APIGatewayProxyResponse{
@@ -93,10 +123,22 @@ func handleRequest(ctx context.Context, req events.APIGatewayProxyRequest) (even
 	}, nil
 }
 
+// PageableResult has no TotalCount with TMDB filters, as films are looked up only up to the page
 type PageableResult struct {
-	Page       int      `json:"page"`
-	Content    []string `json:"content"`
-	TotalCount int      `json:"totalCount"`
+	Page       int         `json:"page"`
+	Size       int         `json:"size"`
+	Content    []LikedFilm `json:"content"`
+	TotalCount *int        `json:"totalCount,omitempty"`
+	NextCursor string      `json:"nextCursor,omitempty"`
+}
+
+type LikedFilm struct {
+	Name    string     `json:"name"`
+	LikedAt *time.Time `json:"likedAt,omitempty"`
+	// filled in only with 'expand=details', has the same fields as a film from get-films
+	*tmdb.ResultRecommendedFilm
+	// position in the stored list counted from the oldest entry, so that films liked later do not change it
+	position int
 }
 
 func getUserIdAndVerify(req events.APIGatewayProxyRequest) (string, error) {
@@ -105,39 +147,116 @@ func getUserIdAndVerify(req events.APIGatewayProxyRequest) (string, error) {
 	return userId.String(), err
 }
 
-func paginateFilms(likedFilms []*dynamodb.AttributeValue, page int, size int) []string {
-	var paginated []string
+// toLikedFilms converts stored entries, keeping their order (newest first). Legacy plain string entries have no time.
+func toLikedFilms(entries []*dynamodb.AttributeValue) []LikedFilm {
+	likedFilms := make([]LikedFilm, 0, len(entries))
+	for index, entry := range entries {
+		position := len(entries) - 1 - index
+		if entry.S != nil {
+			likedFilms = append(likedFilms, LikedFilm{Name: *entry.S, position: position})
+			continue
+		}
+
+		title, ok := entry.M["title"]
+		if !ok || title.S == nil {
+			continue
+		}
+		likedFilm := LikedFilm{Name: *title.S, position: position}
+		if ratedAt, ok := entry.M["ratedAt"]; ok && ratedAt.N != nil {
+			seconds, err := strconv.ParseInt(*ratedAt.N, 10, 64)
+			if err == nil {
+				likedAt := time.Unix(seconds, 0).UTC()
+				likedFilm.LikedAt = &likedAt
+			}
+		}
+		likedFilms = append(likedFilms, likedFilm)
+	}
+
+	return likedFilms
+}
+
+// getDateRange parses optional 'from' and 'to' params, either dates (2006-01-02) or RFC 3339 timestamps.
+// A date in 'to' is inclusive, so it is moved to the end of that day.
+func getDateRange(req events.APIGatewayProxyRequest) (*time.Time, *time.Time, error) {
+	from, err := parseDateParam(req.QueryStringParameters["from"], false)
+	if err != nil {
+		return nil, nil, fmt.Errorf("from - %w", err)
+	}
+	to, err := parseDateParam(req.QueryStringParameters["to"], true)
+	if err != nil {
+		return nil, nil, fmt.Errorf("to - %w", err)
+	}
+	if from != nil && to != nil && from.After(*to) {
+		return nil, nil, fmt.Errorf("from %s is after to %s", from.Format(time.RFC3339), to.Format(time.RFC3339))
+	}
 
-	start := page * size
+	return from, to, nil
+}
 
-	if start > len(likedFilms) {
-		return paginated
+func parseDateParam(value string, endOfDay bool) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
 	}
 
-	end := start + size
-	if end > len(likedFilms) {
-		end = len(likedFilms)
+	if date, err := time.Parse(time.DateOnly, value); err == nil {
+		if endOfDay {
+			date = date.Add(24*time.Hour - time.Second)
+		}
+		return &date, nil
 	}
 
-	for _, film := range likedFilms[start:end] {
-		paginated = append(paginated, film.String())
+	timestamp, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, err
 	}
 
-	return paginated
+	return &timestamp, nil
 }
 
-func sortFilms(likedFilms []string, sortWay string) []string {
-	if sortWay == "" {
+// filterFilmsByDate keeps films liked within the range. Films without time are skipped once any bound is set.
+func filterFilmsByDate(likedFilms []LikedFilm, from *time.Time, to *time.Time) []LikedFilm {
+	if from == nil && to == nil {
 		return likedFilms
 	}
 
-	if sortWay == "ASC" {
-		slices.Sort(likedFilms)
-	} else if sortWay == "DESC" {
-		sort.Slice(likedFilms, func(i, j int) bool {
-			return likedFilms[i] > likedFilms[j]
-		})
+	var filtered []LikedFilm
+	for _, film := range likedFilms {
+		if film.LikedAt == nil {
+			continue
+		}
+		if from != nil && film.LikedAt.Before(*from) {
+			continue
+		}
+		if to != nil && film.LikedAt.After(*to) {
+			continue
+		}
+		filtered = append(filtered, film)
+	}
+
+	return filtered
+}
+
+// expandFilms adds TMDB details to the films. Films not found in TMDB are returned without details
+func expandFilms(likedFilms []LikedFilm) []LikedFilm {
+	filmNames := make([]string, 0, len(likedFilms))
+	for _, film := range likedFilms {
+		filmNames = append(filmNames, film.Name)
+	}
+
+	details := tmdb.GetFilmsDetails(filmNames)
+	for index, film := range likedFilms {
+		if filmDetails, ok := details[film.Name]; ok {
+			likedFilms[index].ResultRecommendedFilm = &filmDetails
+		}
 	}
 
 	return likedFilms
 }
+
+func likedAtUnix(film LikedFilm) int64 {
+	if film.LikedAt == nil {
+		return 0
+	}
+
+	return film.LikedAt.Unix()
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-lambda-go/events"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const maxPageSize = 100
+
+var sortWays = []string{"", "ASC", "DESC", "recent", "oldest"}
+
+type Pagination struct {
+	Page  int
+	Size  int
+	Sort  string
+	After *Cursor
+}
+
+// Cursor points at the last film of the previous page by its name, the time of the like and the stored position.
+// It is handed to clients as an opaque token.
+type Cursor struct {
+	Sort     string `json:"s"`
+	Name     string `json:"n"`
+	LikedAt  int64  `json:"t"`
+	Position int    `json:"p"`
+}
+
+// getPagination reads 'page', 'size', 'sort' and 'cursor' params. Size defaults to and is capped by maxPageSize,
+// a cursor replaces the page and has to be used with the same sort it was issued for.
+func getPagination(req events.APIGatewayProxyRequest) (Pagination, error) {
+	pagination := Pagination{
+		Size: maxPageSize,
+		Sort: req.QueryStringParameters["sort"],
+	}
+	if !slices.Contains(sortWays, pagination.Sort) {
+		return Pagination{}, fmt.Errorf("unknown sort - %s", pagination.Sort)
+	}
+
+	if sizeString := req.QueryStringParameters["size"]; sizeString != "" {
+		size, err := strconv.Atoi(sizeString)
+		if err != nil || size <= 0 {
+			return Pagination{}, fmt.Errorf("size must be a positive number, size - %s", sizeString)
+		}
+		pagination.Size = min(size, maxPageSize)
+	}
+
+	pageString := req.QueryStringParameters["page"]
+	if pageString != "" {
+		page, err := strconv.Atoi(pageString)
+		if err != nil || page < 0 {
+			return Pagination{}, fmt.Errorf("page must be a non-negative number, page - %s", pageString)
+		}
+		pagination.Page = page
+	}
+
+	if cursorString := req.QueryStringParameters["cursor"]; cursorString != "" {
+		if pageString != "" {
+			return Pagination{}, errors.New("page and cursor can not be used together")
+		}
+
+		cursor, err := decodeCursor(cursorString)
+		if err != nil {
+			return Pagination{}, fmt.Errorf("cursor is not correct - %w", err)
+		}
+		if cursor.Sort != pagination.Sort {
+			return Pagination{}, fmt.Errorf("cursor was issued for sort '%s', not '%s'", cursor.Sort, pagination.Sort)
+		}
+		pagination.After = &cursor
+	}
+
+	return pagination, nil
+}
+
+// paginateFilms takes a page from already sorted films and returns a cursor to the next one, if there is one
+func paginateFilms(likedFilms []LikedFilm, pagination Pagination) ([]LikedFilm, string) {
+	paginated := make([]LikedFilm, 0)
+
+	start := pageStart(likedFilms, pagination)
+	if start >= len(likedFilms) {
+		return paginated, ""
+	}
+
+	end := min(start+pagination.Size, len(likedFilms))
+	paginated = append(paginated, likedFilms[start:end]...)
+	if end == len(likedFilms) {
+		return paginated, ""
+	}
+
+	return paginated, encodeCursor(newCursor(likedFilms[end-1], pagination.Sort))
+}
+
+// pageStart is the position of the first film of the page, right after the cursor when there is one
+func pageStart(likedFilms []LikedFilm, pagination Pagination) int {
+	if pagination.After == nil {
+		return pagination.Page * pagination.Size
+	}
+
+	after := pagination.After.toFilm()
+	return sort.Search(len(likedFilms), func(i int) bool {
+		return compareFilms(likedFilms[i], after, pagination.Sort) > 0
+	})
+}
+
+// sortFilms sorts by name with 'ASC'/'DESC' or by time of the like with 'recent'/'oldest', the latest liked
+// first by default. Films without time are older than any timed one and keep the stored order.
+func sortFilms(likedFilms []LikedFilm, sortWay string) []LikedFilm {
+	sort.Slice(likedFilms, func(i, j int) bool {
+		return compareFilms(likedFilms[i], likedFilms[j], sortWay) < 0
+	})
+
+	return likedFilms
+}
+
+// compareFilms defines a total order for every sort way. Ties are resolved by the stored position, so films
+// without time keep the stored order and a cursor points right after a single film, even among duplicate names
+func compareFilms(a LikedFilm, b LikedFilm, sortWay string) int {
+	var result int
+	switch sortWay {
+	case "ASC":
+		result = cmp.Compare(a.Name, b.Name)
+		if result == 0 {
+			result = cmp.Compare(likedAtUnix(a), likedAtUnix(b))
+		}
+		if result == 0 {
+			result = cmp.Compare(a.position, b.position)
+		}
+	case "DESC":
+		result = cmp.Compare(b.Name, a.Name)
+		if result == 0 {
+			result = cmp.Compare(likedAtUnix(b), likedAtUnix(a))
+		}
+		if result == 0 {
+			result = cmp.Compare(b.position, a.position)
+		}
+	case "oldest":
+		result = cmp.Compare(likedAtUnix(a), likedAtUnix(b))
+		if result == 0 {
+			result = cmp.Compare(a.position, b.position)
+		}
+	default:
+		result = cmp.Compare(likedAtUnix(b), likedAtUnix(a))
+		if result == 0 {
+			result = cmp.Compare(b.position, a.position)
+		}
+	}
+
+	return result
+}
+
+func newCursor(film LikedFilm, sortWay string) Cursor {
+	return Cursor{
+		Sort:     sortWay,
+		Name:     film.Name,
+		LikedAt:  likedAtUnix(film),
+		Position: film.position,
+	}
+}
+
+func (c Cursor) toFilm() LikedFilm {
+	film := LikedFilm{Name: c.Name, position: c.Position}
+	if c.LikedAt != 0 {
+		likedAt := time.Unix(c.LikedAt, 0).UTC()
+		film.LikedAt = &likedAt
+	}
+
+	return film
+}
+
+func encodeCursor(cursor Cursor) string {
+	bytes, _ := json.Marshal(cursor)
+
+	return base64.RawURLEncoding.EncodeToString(bytes)
+}
+
+func decodeCursor(value string) (Cursor, error) {
+	bytes, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return Cursor{}, err
+	}
+
+	var cursor Cursor
+	err = json.Unmarshal(bytes, &cursor)
+
+	return cursor, err
+}
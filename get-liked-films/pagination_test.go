@@ -0,0 +1,276 @@
+package main
+
+import (
+	"github.com/aws/aws-lambda-go/events"
+	"slices"
+	"testing"
+	"time"
+)
+
+func likedFilm(name string, likedAt int64) LikedFilm {
+	film := LikedFilm{Name: name}
+	if likedAt != 0 {
+		t := time.Unix(likedAt, 0).UTC()
+		film.LikedAt = &t
+	}
+
+	return film
+}
+
+// storedFilms numbers films like toLikedFilms does, the first one is the latest stored
+func storedFilms(films ...LikedFilm) []LikedFilm {
+	for i := range films {
+		films[i].position = len(films) - 1 - i
+	}
+
+	return films
+}
+
+func filmNames(films []LikedFilm) []string {
+	names := make([]string, 0, len(films))
+	for _, film := range films {
+		names = append(names, film.Name)
+	}
+
+	return names
+}
+
+func TestCursorEncoding(t *testing.T) {
+	tests := []struct {
+		name   string
+		cursor Cursor
+	}{
+		{"default sort", Cursor{Name: "Heat", LikedAt: 1700000000, Position: 3}},
+		{"name sort", Cursor{Sort: "ASC", Name: "Amélie", LikedAt: 1700000000, Position: 12}},
+		{"film without time", Cursor{Sort: "oldest", Name: "Alien"}},
+		{"name with separators", Cursor{Sort: "DESC", Name: "Crouching Tiger, Hidden Dragon / 卧虎藏龙", Position: 1}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			decoded, err := decodeCursor(encodeCursor(test.cursor))
+			if err != nil {
+				t.Fatalf("decodeCursor returned error - %v", err)
+			}
+			if decoded != test.cursor {
+				t.Errorf("decoded cursor %+v, expected %+v", decoded, test.cursor)
+			}
+		})
+	}
+}
+
+func TestDecodeCursorErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+	}{
+		{"not base64", "not a cursor!"},
+		{"not json", "bm90IGpzb24"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := decodeCursor(test.value); err == nil {
+				t.Errorf("decodeCursor(%q) returned no error", test.value)
+			}
+		})
+	}
+}
+
+func TestGetPagination(t *testing.T) {
+	ascCursor := encodeCursor(Cursor{Sort: "ASC", Name: "Heat"})
+	tests := []struct {
+		name    string
+		params  map[string]string
+		want    Pagination
+		wantErr bool
+	}{
+		{"defaults", map[string]string{}, Pagination{Size: maxPageSize}, false},
+		{"page and size", map[string]string{"page": "2", "size": "10"}, Pagination{Page: 2, Size: 10}, false},
+		{"size capped", map[string]string{"size": "1000"}, Pagination{Size: maxPageSize}, false},
+		{"zero size", map[string]string{"size": "0"}, Pagination{}, true},
+		{"negative page", map[string]string{"page": "-1"}, Pagination{}, true},
+		{"unknown sort", map[string]string{"sort": "rating"}, Pagination{}, true},
+		{"page with cursor", map[string]string{"page": "1", "sort": "ASC", "cursor": ascCursor}, Pagination{}, true},
+		{"cursor of another sort", map[string]string{"sort": "DESC", "cursor": ascCursor}, Pagination{}, true},
+		{"broken cursor", map[string]string{"cursor": "###"}, Pagination{}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pagination, err := getPagination(events.APIGatewayProxyRequest{QueryStringParameters: test.params})
+			if (err != nil) != test.wantErr {
+				t.Fatalf("getPagination returned error - %v, expected error - %t", err, test.wantErr)
+			}
+			if !test.wantErr && pagination != test.want {
+				t.Errorf("getPagination returned %+v, expected %+v", pagination, test.want)
+			}
+		})
+	}
+}
+
+func TestSortFilms(t *testing.T) {
+	tests := []struct {
+		sortWay string
+		want    []string
+	}{
+		{"", []string{"Heat", "Up", "Alien", "Brazil"}},
+		{"recent", []string{"Heat", "Up", "Alien", "Brazil"}},
+		{"oldest", []string{"Brazil", "Alien", "Up", "Heat"}},
+		{"ASC", []string{"Alien", "Brazil", "Heat", "Up"}},
+		{"DESC", []string{"Up", "Heat", "Brazil", "Alien"}},
+	}
+
+	for _, test := range tests {
+		t.Run("sort "+test.sortWay, func(t *testing.T) {
+			films := storedFilms(
+				likedFilm("Up", 200),
+				likedFilm("Brazil", 0),
+				likedFilm("Heat", 300),
+				likedFilm("Alien", 200),
+			)
+			if names := filmNames(sortFilms(films, test.sortWay)); !slices.Equal(names, test.want) {
+				t.Errorf("sorted films %v, expected %v", names, test.want)
+			}
+		})
+	}
+}
+
+func TestPaginateFilms(t *testing.T) {
+	films := sortFilms(storedFilms(
+		likedFilm("Vertigo", 500),
+		likedFilm("Up", 400),
+		likedFilm("Heat", 300),
+		likedFilm("Brazil", 200),
+		likedFilm("Alien", 100),
+	), "oldest")
+
+	tests := []struct {
+		name       string
+		films      []LikedFilm
+		pagination Pagination
+		want       []string
+		wantNext   bool
+	}{
+		{"first page", films, Pagination{Size: 2, Sort: "oldest"}, []string{"Alien", "Brazil"}, true},
+		{"page by number", films, Pagination{Page: 1, Size: 2, Sort: "oldest"}, []string{"Heat", "Up"}, true},
+		{"last page", films, Pagination{Page: 2, Size: 2, Sort: "oldest"}, []string{"Vertigo"}, false},
+		{"page past the end", films, Pagination{Page: 3, Size: 2, Sort: "oldest"}, []string{}, false},
+		{
+			"after cursor",
+			films,
+			Pagination{Size: 2, Sort: "oldest", After: &Cursor{Sort: "oldest", Name: "Brazil", LikedAt: 200, Position: 1}},
+			[]string{"Heat", "Up"},
+			true,
+		},
+		{
+			"after cursor of a deleted film",
+			[]LikedFilm{films[0], films[2], films[3], films[4]},
+			Pagination{Size: 2, Sort: "oldest", After: &Cursor{Sort: "oldest", Name: "Brazil", LikedAt: 200, Position: 1}},
+			[]string{"Heat", "Up"},
+			true,
+		},
+		{
+			"after cursor of the last film",
+			films,
+			Pagination{Size: 2, Sort: "oldest", After: &Cursor{Sort: "oldest", Name: "Vertigo", LikedAt: 500, Position: 4}},
+			[]string{},
+			false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			page, next := paginateFilms(test.films, test.pagination)
+			if names := filmNames(page); !slices.Equal(names, test.want) {
+				t.Errorf("page films %v, expected %v", names, test.want)
+			}
+			if (next != "") != test.wantNext {
+				t.Errorf("next cursor %q, expected a next cursor - %t", next, test.wantNext)
+			}
+		})
+	}
+}
+
+func TestPaginateFilmsFollowsCursors(t *testing.T) {
+	tests := []struct {
+		name    string
+		films   []LikedFilm
+		sortWay string
+		want    []string
+	}{
+		{
+			"name sort",
+			storedFilms(
+				likedFilm("Vertigo", 500),
+				likedFilm("Up", 400),
+				likedFilm("Heat", 0),
+				likedFilm("Brazil", 100),
+				likedFilm("Alien", 100),
+			),
+			"ASC",
+			[]string{"Alien", "Brazil", "Heat", "Up", "Vertigo"},
+		},
+		{
+			"films without time keep the stored order",
+			storedFilms(
+				likedFilm("Heat", 0),
+				likedFilm("Alien", 0),
+				likedFilm("Alien", 0),
+				likedFilm("Up", 0),
+				likedFilm("Brazil", 0),
+			),
+			"",
+			[]string{"Heat", "Alien", "Alien", "Up", "Brazil"},
+		},
+		{
+			"films without time, the oldest first",
+			storedFilms(
+				likedFilm("Heat", 0),
+				likedFilm("Alien", 0),
+				likedFilm("Up", 0),
+				likedFilm("Alien", 0),
+				likedFilm("Brazil", 0),
+			),
+			"oldest",
+			[]string{"Brazil", "Alien", "Up", "Alien", "Heat"},
+		},
+		{
+			"same name on both sides of a page",
+			storedFilms(
+				likedFilm("Heat", 300),
+				likedFilm("Alien", 0),
+				likedFilm("Brazil", 0),
+				likedFilm("Alien", 0),
+				likedFilm("Alien", 0),
+			),
+			"ASC",
+			[]string{"Alien", "Alien", "Alien", "Brazil", "Heat"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			films := sortFilms(test.films, test.sortWay)
+
+			var names []string
+			pagination := Pagination{Size: 2, Sort: test.sortWay}
+			for {
+				page, next := paginateFilms(films, pagination)
+				names = append(names, filmNames(page)...)
+				if next == "" {
+					break
+				}
+				cursor, err := decodeCursor(next)
+				if err != nil {
+					t.Fatalf("decodeCursor returned error - %v", err)
+				}
+				pagination.After = &cursor
+			}
+
+			if !slices.Equal(names, test.want) {
+				t.Errorf("films of all pages %v, expected %v", names, test.want)
+			}
+		})
+	}
+}
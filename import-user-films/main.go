@@ -0,0 +1,392 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"finder/common/idempotency"
+	"finder/common/profile"
+	"finder/common/tmdb"
+	"fmt"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/google/uuid"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var sess = session.Must(session.NewSession())
+var db = dynamodb.New(sess)
+
+// ratings are out of 10, anything between the thresholds is neutral and is not imported
+var likeThreshold = 7.0
+var unlikeThreshold = 4.0
+
+var batchSize = 100
+var maxRetries = 3
+var maxConcurrentLookups = 8
+
+// films are looked up in TMDB within the request, bigger exports are to be split so that an import finishes
+// before the API Gateway timeout of 29 seconds
+var maxImportedFilms = 500
+
+func main() {
+	lambda.Start(idempotency.Wrap("import-user-films", handleRequest))
+}
+
+func handleRequest(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	userId, err := getUserIdAndVerify(req)
+	if err != nil {
+		id := req.QueryStringParameters["id"]
+		log.Printf("Provided user id is not correct, user id - %s", id)
+		return events.APIGatewayProxyResponse{
+			StatusCode: 400,
+			Body:       "Provided user id is not correct, user id - " + id,
+		}, nil
+	}
+
+	body := []byte(req.Body)
+	if req.IsBase64Encoded {
+		body, err = base64.StdEncoding.DecodeString(req.Body)
+		if err != nil {
+			log.Printf("Got error decoding request body: %v", err)
+			return events.APIGatewayProxyResponse{
+				StatusCode: 400,
+				Body:       "Got error decoding request body: " + err.Error(),
+			}, nil
+		}
+	}
+
+	importedFilms, err := parseExport(req.QueryStringParameters["format"], body)
+	if err != nil {
+		log.Printf("Got error parsing export: %v", err)
+		return events.APIGatewayProxyResponse{
+			StatusCode: 400,
+			Body:       "Got error parsing export: " + err.Error(),
+		}, nil
+	}
+
+	report := ImportReport{Total: len(importedFilms), Unmatched: make([]UnmatchedFilm, 0)}
+	watchedAsLike := req.QueryStringParameters["watched"] == "like"
+	var likedFilms, unlikedFilms []ImportedFilm
+	for _, film := range importedFilms {
+		switch ratingMethod(film, watchedAsLike) {
+		case "like":
+			likedFilms = append(likedFilms, film)
+		case "unlike":
+			unlikedFilms = append(unlikedFilms, film)
+		default:
+			report.Neutral++
+		}
+	}
+
+	if filmCount := len(likedFilms) + len(unlikedFilms); filmCount > maxImportedFilms {
+		log.Printf("Export has too many films to import, user id - %s, films - %d", userId, filmCount)
+		return events.APIGatewayProxyResponse{
+			StatusCode: 413,
+			Body:       fmt.Sprintf("Export has %d liked and not liked films, at most %d can be imported at once, split the export", filmCount, maxImportedFilms),
+		}, nil
+	}
+
+	likedEntries := resolveFilms(likedFilms, &report)
+	unlikedEntries := resolveFilms(unlikedFilms, &report)
+
+	err = writeInBatches(userId, likedEntries, unlikedEntries, &report)
+	if err != nil {
+		log.Printf("Got error writing imported films, user id - %s, error - %v", userId, err)
+		report.Error = err.Error()
+	}
+	if report.Liked+report.Unliked > 0 {
+		profile.PublishChanged(userId, profile.Imported, nil)
+	}
+
+	jsonReport, jsonErr := json.Marshal(report)
+	if jsonErr != nil {
+		log.Printf("Got error parsing to result JSON: %v", report)
+		return events.APIGatewayProxyResponse{
+			StatusCode: 500,
+			Body:       "Got error parsing import report to result JSON: " + jsonErr.Error(),
+		}, nil
+	}
+
+	statusCode := 200
+	if err != nil {
+		statusCode = 500
+	}
+	return events.APIGatewayProxyResponse{
+		StatusCode: statusCode,
+		Body:       string(jsonReport),
+	}, nil
+}
+
+type ImportReport struct {
+	Total        int             `json:"total"`
+	Liked        int             `json:"liked"`
+	Unliked      int             `json:"unliked"`
+	Neutral      int             `json:"neutral"`
+	AlreadyRated int             `json:"alreadyRated"`
+	Unmatched    []UnmatchedFilm `json:"unmatched"`
+	Error        string          `json:"error,omitempty"`
+}
+
+type UnmatchedFilm struct {
+	Title string `json:"title"`
+	Year  string `json:"year,omitempty"`
+}
+
+func getUserIdAndVerify(req events.APIGatewayProxyRequest) (string, error) {
+	userId, err := uuid.Parse(req.QueryStringParameters["id"])
+
+	return userId.String(), err
+}
+
+// ratingMethod maps a rating to 'like' or 'unlike'. Films without a rating are only watched,
+// they are liked if asked so and are neutral otherwise.
+func ratingMethod(film ImportedFilm, watchedAsLike bool) string {
+	if film.Rating == 0 {
+		if watchedAsLike {
+			return "like"
+		}
+		return ""
+	}
+
+	if film.Rating >= likeThreshold {
+		return "like"
+	} else if film.Rating <= unlikeThreshold {
+		return "unlike"
+	}
+
+	return ""
+}
+
+// resolveFilms finds every film in TMDB and builds list entries for the found ones, newest first.
+// Films which are not found are added to the report.
+func resolveFilms(films []ImportedFilm, report *ImportReport) []*dynamodb.AttributeValue {
+	matches := make([]*tmdb.MovieMatch, len(films))
+	var wg sync.WaitGroup
+	semaphore := make(chan struct{}, maxConcurrentLookups)
+
+	for index, film := range films {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(index int, film ImportedFilm) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			match, err := findMovie(film)
+			if err != nil {
+				log.Printf("Film is not found in TMDB, film - %s (%s), error - %v", film.Title, film.Year, err)
+				return
+			}
+			matches[index] = &match
+		}(index, film)
+	}
+	wg.Wait()
+
+	var resolved []ImportedFilm
+	for index, film := range films {
+		if matches[index] == nil {
+			report.Unmatched = append(report.Unmatched, UnmatchedFilm{Title: film.Title, Year: film.Year})
+			continue
+		}
+		film.TmdbId = matches[index].ID
+		film.Title = matches[index].Title
+		resolved = append(resolved, film)
+	}
+
+	sort.SliceStable(resolved, func(i, j int) bool {
+		return resolved[i].RatedAt.After(resolved[j].RatedAt)
+	})
+	entries := make([]*dynamodb.AttributeValue, 0, len(resolved))
+	for _, film := range resolved {
+		entries = append(entries, newFilmEntry(film))
+	}
+
+	return entries
+}
+
+// findMovie uses the most precise id the export has, falling back to the search by name and year
+func findMovie(film ImportedFilm) (tmdb.MovieMatch, error) {
+	if film.TmdbId != 0 {
+		match, err := tmdb.FindMovieById(film.TmdbId)
+		if err == nil {
+			return match, nil
+		}
+	}
+	if film.ImdbId != "" {
+		match, err := tmdb.FindMovieByImdbId(film.ImdbId)
+		if err == nil {
+			return match, nil
+		}
+	}
+
+	return tmdb.FindMovie(film.Title, film.Year)
+}
+
+func newFilmEntry(film ImportedFilm) *dynamodb.AttributeValue {
+	ratedAt := film.RatedAt
+	if ratedAt.IsZero() {
+		ratedAt = time.Now()
+	}
+
+	entry := map[string]*dynamodb.AttributeValue{
+		"title": {
+			S: aws.String(film.Title),
+		},
+		"ratedAt": {
+			N: aws.String(strconv.FormatInt(ratedAt.Unix(), 10)),
+		},
+		"tmdbId": {
+			N: aws.String(strconv.Itoa(film.TmdbId)),
+		},
+	}
+	if film.Rating != 0 {
+		entry["rating"] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatFloat(film.Rating, 'f', -1, 64))}
+	}
+
+	return &dynamodb.AttributeValue{M: entry}
+}
+
+// writeInBatches appends imported entries after the already rated films, batch by batch,
+// so that a big export does not end up in one huge update. Films the user already rated are skipped.
+func writeInBatches(userId string, likedEntries []*dynamodb.AttributeValue, unlikedEntries []*dynamodb.AttributeValue, report *ImportReport) error {
+	for start := 0; start < len(likedEntries); start += batchSize {
+		end := min(start+batchSize, len(likedEntries))
+		written, skipped, err := compareAndSetAppend(userId, "likedFilms", likedEntries[start:end])
+		report.Liked += written
+		report.AlreadyRated += skipped
+		if err != nil {
+			return err
+		}
+	}
+
+	for start := 0; start < len(unlikedEntries); start += batchSize {
+		end := min(start+batchSize, len(unlikedEntries))
+		written, skipped, err := compareAndSetAppend(userId, "unlikedFilms", unlikedEntries[start:end])
+		report.Unliked += written
+		report.AlreadyRated += skipped
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func compareAndSetAppend(userId string, listName string, entries []*dynamodb.AttributeValue) (int, int, error) {
+	var err error
+	for attempts := 0; attempts < maxRetries; attempts++ {
+		var result *dynamodb.GetItemOutput
+		result, err = db.GetItem(&dynamodb.GetItemInput{
+			TableName: aws.String("user_films"),
+			Key: map[string]*dynamodb.AttributeValue{
+				"id": {
+					S: aws.String(userId),
+				},
+			},
+		})
+		if err != nil {
+			return 0, 0, fmt.Errorf("got error calling GetItem: %w", err)
+		}
+
+		oldLikedFilms := []*dynamodb.AttributeValue{}
+		oldUnlikedFilms := []*dynamodb.AttributeValue{}
+		if result.Item != nil {
+			if result.Item["likedFilms"] != nil {
+				oldLikedFilms = result.Item["likedFilms"].L
+			}
+			if result.Item["unlikedFilms"] != nil {
+				oldUnlikedFilms = result.Item["unlikedFilms"].L
+			}
+		}
+
+		rated := ratedFilmKeys(append(append([]*dynamodb.AttributeValue{}, oldLikedFilms...), oldUnlikedFilms...))
+		var newEntries []*dynamodb.AttributeValue
+		for _, entry := range entries {
+			title := strings.ToLower(*entry.M["title"].S)
+			if rated[entryKey(entry)] || rated[title] {
+				continue
+			}
+			rated[entryKey(entry)] = true
+			rated[title] = true
+			newEntries = append(newEntries, entry)
+		}
+		skipped := len(entries) - len(newEntries)
+		if len(newEntries) == 0 {
+			return 0, skipped, nil
+		}
+
+		resultLikedFilms := oldLikedFilms
+		resultUnlikedFilms := oldUnlikedFilms
+		if listName == "likedFilms" {
+			resultLikedFilms = append(append([]*dynamodb.AttributeValue{}, oldLikedFilms...), newEntries...)
+		} else {
+			resultUnlikedFilms = append(append([]*dynamodb.AttributeValue{}, oldUnlikedFilms...), newEntries...)
+		}
+
+		_, err = db.UpdateItem(&dynamodb.UpdateItemInput{
+			TableName: aws.String("user_films"),
+			Key: map[string]*dynamodb.AttributeValue{
+				"id": {
+					S: aws.String(userId),
+				},
+			},
+			ConditionExpression: aws.String("(attribute_not_exists(likedFilms) OR likedFilms = :oldLikedFilms) " +
+				"AND (attribute_not_exists(unlikedFilms) OR unlikedFilms = :oldUnlikedFilms)"),
+			UpdateExpression: aws.String("SET likedFilms = :likedFilms, unlikedFilms = :unlikedFilms"),
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+				":oldLikedFilms": {
+					L: oldLikedFilms,
+				},
+				":oldUnlikedFilms": {
+					L: oldUnlikedFilms,
+				},
+				":likedFilms": {
+					L: resultLikedFilms,
+				},
+				":unlikedFilms": {
+					L: resultUnlikedFilms,
+				},
+			},
+		})
+		if err == nil {
+			return len(newEntries), skipped, nil
+		}
+	}
+
+	return 0, 0, fmt.Errorf("got error calling UpdateItem: %w", err)
+}
+
+// ratedFilmKeys collects TMDB ids and lower-cased titles of already rated films
+func ratedFilmKeys(entries []*dynamodb.AttributeValue) map[string]bool {
+	keys := make(map[string]bool, len(entries)*2)
+	for _, entry := range entries {
+		if entry.S != nil {
+			keys[strings.ToLower(*entry.S)] = true
+			continue
+		}
+		if title, ok := entry.M["title"]; ok && title.S != nil {
+			keys[strings.ToLower(*title.S)] = true
+		}
+		if key := entryKey(entry); key != "" {
+			keys[key] = true
+		}
+	}
+
+	return keys
+}
+
+func entryKey(entry *dynamodb.AttributeValue) string {
+	if tmdbId, ok := entry.M["tmdbId"]; ok && tmdbId.N != nil {
+		return "tmdb:" + *tmdbId.N
+	}
+
+	return ""
+}
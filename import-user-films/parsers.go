@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ImportedFilm is one row of an export. Rating is out of 10 and is 0 when the row has no rating (watched only).
+type ImportedFilm struct {
+	Title   string
+	Year    string
+	ImdbId  string
+	TmdbId  int
+	Rating  float64
+	RatedAt time.Time
+}
+
+// parseExport reads films from 'letterboxd' (ratings.csv or watched.csv), 'imdb' (ratings CSV) or 'trakt' (JSON) exports
+func parseExport(format string, body []byte) ([]ImportedFilm, error) {
+	switch format {
+	case "letterboxd":
+		return parseLetterboxdCsv(body)
+	case "imdb":
+		return parseImdbCsv(body)
+	case "trakt":
+		return parseTraktJson(body)
+	default:
+		return nil, fmt.Errorf("unknown format - %s, expected 'letterboxd', 'imdb' or 'trakt'", format)
+	}
+}
+
+// parseLetterboxdCsv reads 'Date,Name,Year,Letterboxd URI[,Rating]'. Letterboxd rates from 0.5 to 5 stars.
+func parseLetterboxdCsv(body []byte) ([]ImportedFilm, error) {
+	records, err := readCsv(body, "Name", "Date")
+	if err != nil {
+		return nil, err
+	}
+
+	films := make([]ImportedFilm, 0, len(records))
+	for _, record := range records {
+		film := ImportedFilm{
+			Title:   record["Name"],
+			Year:    record["Year"],
+			RatedAt: parseDate(record["Date"]),
+		}
+		if rating := record["Rating"]; rating != "" {
+			stars, err := strconv.ParseFloat(rating, 64)
+			if err != nil {
+				return nil, fmt.Errorf("rating of %s is not correct - %s", film.Title, rating)
+			}
+			film.Rating = stars * 2
+		}
+		films = append(films, film)
+	}
+
+	return films, nil
+}
+
+// parseImdbCsv reads IMDb ratings export, only films are taken, series and episodes are left out
+func parseImdbCsv(body []byte) ([]ImportedFilm, error) {
+	records, err := readCsv(body, "Const", "Your Rating", "Title")
+	if err != nil {
+		return nil, err
+	}
+
+	films := make([]ImportedFilm, 0, len(records))
+	for _, record := range records {
+		titleType := strings.ToLower(record["Title Type"])
+		if titleType != "" && !strings.Contains(titleType, "movie") {
+			continue
+		}
+
+		rating, err := strconv.ParseFloat(record["Your Rating"], 64)
+		if err != nil {
+			return nil, fmt.Errorf("rating of %s is not correct - %s", record["Title"], record["Your Rating"])
+		}
+		films = append(films, ImportedFilm{
+			Title:   record["Title"],
+			Year:    record["Year"],
+			ImdbId:  record["Const"],
+			Rating:  rating,
+			RatedAt: parseDate(record["Date Rated"]),
+		})
+	}
+
+	return films, nil
+}
+
+// parseTraktJson reads Trakt ratings, watched or history exports, only films are taken
+func parseTraktJson(body []byte) ([]ImportedFilm, error) {
+	var items []traktItem
+	err := json.Unmarshal(body, &items)
+	if err != nil {
+		return nil, err
+	}
+
+	films := make([]ImportedFilm, 0, len(items))
+	for _, item := range items {
+		if item.Movie == nil {
+			continue
+		}
+
+		film := ImportedFilm{
+			Title:  item.Movie.Title,
+			ImdbId: item.Movie.Ids.Imdb,
+			TmdbId: item.Movie.Ids.Tmdb,
+			Rating: item.Rating,
+		}
+		if item.Movie.Year != 0 {
+			film.Year = strconv.Itoa(item.Movie.Year)
+		}
+		for _, date := range []string{item.RatedAt, item.WatchedAt, item.LastWatchedAt} {
+			if date != "" {
+				film.RatedAt = parseDate(date)
+				break
+			}
+		}
+		films = append(films, film)
+	}
+
+	return films, nil
+}
+
+// readCsv reads rows as maps by the header names, checking that the required columns are present
+func readCsv(body []byte, requiredColumns ...string) ([]map[string]string, error) {
+	reader := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(body, []byte("\ufeff"))))
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("could not read CSV header - %w", err)
+	}
+	columns := make(map[string]int, len(header))
+	for index, column := range header {
+		columns[strings.TrimSpace(column)] = index
+	}
+	for _, column := range requiredColumns {
+		if _, ok := columns[column]; !ok {
+			return nil, fmt.Errorf("CSV has no '%s' column", column)
+		}
+	}
+
+	var records []map[string]string
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		record := make(map[string]string, len(columns))
+		for column, index := range columns {
+			if index < len(row) {
+				record[column] = strings.TrimSpace(row[index])
+			}
+		}
+		records = append(records, record)
+	}
+
+	return records, nil
+}
+
+// parseDate reads dates (2006-01-02) and RFC 3339 timestamps, zero time is returned when there is no date
+func parseDate(value string) time.Time {
+	if date, err := time.Parse(time.DateOnly, value); err == nil {
+		return date
+	}
+	if timestamp, err := time.Parse(time.RFC3339, value); err == nil {
+		return timestamp
+	}
+
+	return time.Time{}
+}
+
+type traktItem struct {
+	RatedAt       string      `json:"rated_at"`
+	WatchedAt     string      `json:"watched_at"`
+	LastWatchedAt string      `json:"last_watched_at"`
+	Rating        float64     `json:"rating"`
+	Movie         *traktMovie `json:"movie"`
+}
+
+type traktMovie struct {
+	Title string `json:"title"`
+	Year  int    `json:"year"`
+	Ids   struct {
+		Tmdb int    `json:"tmdb"`
+		Imdb string `json:"imdb"`
+	} `json:"ids"`
+}
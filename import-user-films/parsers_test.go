@@ -0,0 +1,133 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+func TestParseExport(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		body    string
+		want    []ImportedFilm
+		wantErr bool
+	}{
+		{
+			name:   "letterboxd ratings",
+			format: "letterboxd",
+			body: "Date,Name,Year,Letterboxd URI,Rating\n" +
+				"2023-05-01,Heat,1995,https://boxd.it/2a0A,4.5\n" +
+				"2023-06-02,\"Crouching Tiger, Hidden Dragon\",2000,https://boxd.it/1Xy4,0.5\n",
+			want: []ImportedFilm{
+				{Title: "Heat", Year: "1995", Rating: 9, RatedAt: date(2023, 5, 1)},
+				{Title: "Crouching Tiger, Hidden Dragon", Year: "2000", Rating: 1, RatedAt: date(2023, 6, 2)},
+			},
+		},
+		{
+			name:   "letterboxd watched without ratings",
+			format: "letterboxd",
+			body:   "\ufeffDate,Name,Year,Letterboxd URI\n2023-05-01,Heat,1995,https://boxd.it/2a0A\n",
+			want:   []ImportedFilm{{Title: "Heat", Year: "1995", RatedAt: date(2023, 5, 1)}},
+		},
+		{
+			name:    "letterboxd wrong rating",
+			format:  "letterboxd",
+			body:    "Date,Name,Year,Letterboxd URI,Rating\n2023-05-01,Heat,1995,https://boxd.it/2a0A,great\n",
+			wantErr: true,
+		},
+		{
+			name:    "letterboxd without name column",
+			format:  "letterboxd",
+			body:    "Date,Title,Year\n2023-05-01,Heat,1995\n",
+			wantErr: true,
+		},
+		{
+			name:   "imdb films only",
+			format: "imdb",
+			body: "Const,Your Rating,Date Rated,Title,Title Type,Year\n" +
+				"tt0113277,9,2023-05-01,Heat,Movie,1995\n" +
+				"tt0903747,10,2023-05-02,Breaking Bad,TV Series,2008\n" +
+				"tt0133093,3,,The Matrix,tvMovie,1999\n",
+			want: []ImportedFilm{
+				{Title: "Heat", Year: "1995", ImdbId: "tt0113277", Rating: 9, RatedAt: date(2023, 5, 1)},
+				{Title: "The Matrix", Year: "1999", ImdbId: "tt0133093", Rating: 3},
+			},
+		},
+		{
+			name:    "imdb wrong rating",
+			format:  "imdb",
+			body:    "Const,Your Rating,Title\ntt0113277,,Heat\n",
+			wantErr: true,
+		},
+		{
+			name:   "trakt ratings and history",
+			format: "trakt",
+			body: `[
+				{"rated_at": "2023-05-01T20:15:00Z", "rating": 8, "movie": {"title": "Heat", "year": 1995, "ids": {"tmdb": 949, "imdb": "tt0113277"}}},
+				{"rated_at": "2023-05-02T20:15:00Z", "rating": 7, "show": {"title": "Breaking Bad"}},
+				{"watched_at": "2023-05-03T21:00:00Z", "movie": {"title": "Alien", "ids": {"tmdb": 348}}},
+				{"last_watched_at": "2023-05-04T22:00:00Z", "movie": {"title": "Up", "year": 2009, "ids": {}}}
+			]`,
+			want: []ImportedFilm{
+				{Title: "Heat", Year: "1995", ImdbId: "tt0113277", TmdbId: 949, Rating: 8, RatedAt: time.Date(2023, 5, 1, 20, 15, 0, 0, time.UTC)},
+				{Title: "Alien", TmdbId: 348, RatedAt: time.Date(2023, 5, 3, 21, 0, 0, 0, time.UTC)},
+				{Title: "Up", Year: "2009", RatedAt: time.Date(2023, 5, 4, 22, 0, 0, 0, time.UTC)},
+			},
+		},
+		{
+			name:    "trakt not json",
+			format:  "trakt",
+			body:    "Date,Name\n",
+			wantErr: true,
+		},
+		{
+			name:    "unknown format",
+			format:  "netflix",
+			body:    "Title,Date\n",
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			films, err := parseExport(test.format, []byte(test.body))
+			if (err != nil) != test.wantErr {
+				t.Fatalf("parseExport returned error - %v, expected error - %t", err, test.wantErr)
+			}
+			if !test.wantErr && !slices.Equal(films, test.want) {
+				t.Errorf("parseExport returned %+v, expected %+v", films, test.want)
+			}
+		})
+	}
+}
+
+func TestRatingMethod(t *testing.T) {
+	tests := []struct {
+		name          string
+		rating        float64
+		watchedAsLike bool
+		want          string
+	}{
+		{"high rating", 9, false, "like"},
+		{"like threshold", likeThreshold, false, "like"},
+		{"middle rating", (likeThreshold + unlikeThreshold) / 2, false, ""},
+		{"unlike threshold", unlikeThreshold, false, "unlike"},
+		{"low rating", 1, true, "unlike"},
+		{"watched only", 0, false, ""},
+		{"watched only as like", 0, true, "like"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if method := ratingMethod(ImportedFilm{Rating: test.rating}, test.watchedAsLike); method != test.want {
+				t.Errorf("ratingMethod returned %q, expected %q", method, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"finder/common/idempotency"
+	"finder/common/profile"
+	"fmt"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/google/uuid"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var sess = session.Must(session.NewSession())
+var db = dynamodb.New(sess)
+
+// deletedTable keeps profiles removed by clear-state-films until their 'expiresAt', a snapshot per clear
+var deletedTable = "user_films_deleted"
+
+var maxRetries = 3
+
+// scopeLists are the user_films lists restored by every scope, the same as cleared by clear-state-films.
+// Snapshots made before the cleared lists were recorded are taken as clears of all of them.
+var scopeLists = map[string][]string{
+	"liked":   {"likedFilms"},
+	"unliked": {"unlikedFilms"},
+	"history": {"recommendedFilms"},
+	"all":     {"likedFilms", "unlikedFilms", "recommendedFilms"},
+}
+
+// a film is either liked or not liked, so a restored film is skipped when it was rated the other way after clearing
+var oppositeLists = map[string]string{
+	"likedFilms":   "unlikedFilms",
+	"unlikedFilms": "likedFilms",
+}
+
+var errNothingToRestore = errors.New("nothing to restore")
+
+func main() {
+	lambda.Start(idempotency.Wrap("restore-state-films", handleRequest))
+}
+
+func handleRequest(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	userId, err := getUserIdAndVerify(req)
+	if err != nil {
+		id := req.QueryStringParameters["id"]
+		log.Printf("Provided user id is not correct, user id - %s", id)
+		return events.APIGatewayProxyResponse{
+			StatusCode: 400,
+			Body:       "Provided user id is not correct, user id - " + id,
+		}, nil
+	}
+
+	scope := req.QueryStringParameters["scope"]
+	if scope == "" {
+		scope = "all"
+	}
+	lists, ok := scopeLists[scope]
+	if !ok {
+		log.Printf("Provided scope is not correct, scope - %s", scope)
+		return events.APIGatewayProxyResponse{
+			StatusCode: 400,
+			Body:       "Provided scope is not correct, expected 'liked', 'unliked', 'history' or 'all', scope - " + scope,
+		}, nil
+	}
+
+	err = restore(userId, lists)
+	if errors.Is(err, errNothingToRestore) {
+		return events.APIGatewayProxyResponse{
+			StatusCode: 404,
+			Body:       "Nothing to restore, films were not cleared or the restore window has passed.",
+		}, nil
+	}
+	if err != nil {
+		log.Printf("Got error restoring user films: %s", err)
+		return events.APIGatewayProxyResponse{
+			StatusCode: 500,
+			Body:       "Got error restoring user films: " + err.Error(),
+		}, nil
+	}
+	profile.PublishChanged(userId, profile.Restored, nil)
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 204,
+	}, nil
+}
+
+func getUserIdAndVerify(req events.APIGatewayProxyRequest) (string, error) {
+	userId, err := uuid.Parse(req.QueryStringParameters["id"])
+
+	return userId.String(), err
+}
+
+// restore brings back the lists of the latest snapshot which cleared any of the given lists. Films rated after
+// clearing are kept, in front of the restored ones. The snapshot is removed once all of its lists are restored,
+// otherwise it keeps the rest of them for a later restore.
+func restore(userId string, lists []string) error {
+	key := map[string]*dynamodb.AttributeValue{
+		"id": {
+			S: aws.String(userId),
+		},
+	}
+
+	for attempts := 0; attempts < maxRetries; attempts++ {
+		snapshot, err := getLatestSnapshot(userId, lists)
+		if err != nil {
+			return err
+		}
+		if snapshot == nil {
+			return errNothingToRestore
+		}
+
+		current, err := db.GetItem(&dynamodb.GetItemInput{
+			TableName:      aws.String("user_films"),
+			Key:            key,
+			ConsistentRead: aws.Bool(true),
+		})
+		if err != nil {
+			return fmt.Errorf("got error calling GetItem: %w", err)
+		}
+
+		var restoredLists, remainingLists []string
+		for _, list := range snapshotLists(snapshot) {
+			if slices.Contains(lists, list) {
+				restoredLists = append(restoredLists, list)
+			} else {
+				remainingLists = append(remainingLists, list)
+			}
+		}
+
+		restored := restoreLists(current.Item, snapshot["snapshot"].M, restoredLists)
+		restored["id"] = &dynamodb.AttributeValue{S: aws.String(userId)}
+
+		conditionExpression, names, values := unchangedItemCondition(current.Item)
+		_, err = db.TransactWriteItems(&dynamodb.TransactWriteItemsInput{
+			TransactItems: []*dynamodb.TransactWriteItem{
+				snapshotWrite(snapshot, remainingLists),
+				{
+					Put: &dynamodb.Put{
+						TableName:                 aws.String("user_films"),
+						Item:                      restored,
+						ConditionExpression:       aws.String(conditionExpression),
+						ExpressionAttributeNames:  names,
+						ExpressionAttributeValues: values,
+					},
+				},
+			},
+		})
+		if err == nil {
+			return nil
+		}
+
+		var canceled *dynamodb.TransactionCanceledException
+		if !errors.As(err, &canceled) || attempts == maxRetries-1 {
+			return fmt.Errorf("got error calling TransactWriteItems: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// getLatestSnapshot reads the latest snapshot of the user which is not expired and cleared any of the lists
+func getLatestSnapshot(userId string, lists []string) (map[string]*dynamodb.AttributeValue, error) {
+	result, err := db.Query(&dynamodb.QueryInput{
+		TableName:              aws.String(deletedTable),
+		KeyConditionExpression: aws.String("id = :id"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":id": {
+				S: aws.String(userId),
+			},
+		},
+		ScanIndexForward: aws.Bool(false),
+		ConsistentRead:   aws.Bool(true),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("got error calling Query: %w", err)
+	}
+
+	for _, item := range result.Items {
+		// TTL removes expired items lazily, so expiration is checked here as well
+		if item["snapshot"] == nil || item["snapshot"].M == nil || isExpired(item["expiresAt"]) {
+			continue
+		}
+		for _, list := range snapshotLists(item) {
+			if slices.Contains(lists, list) {
+				return item, nil
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+// snapshotLists are the lists cleared when the snapshot was made
+func snapshotLists(snapshot map[string]*dynamodb.AttributeValue) []string {
+	if snapshot["lists"] == nil || snapshot["lists"].SS == nil {
+		return scopeLists["all"]
+	}
+
+	return aws.StringValueSlice(snapshot["lists"].SS)
+}
+
+// snapshotWrite removes the snapshot when no lists remain in it, or keeps only the remaining lists in it
+func snapshotWrite(snapshot map[string]*dynamodb.AttributeValue, remainingLists []string) *dynamodb.TransactWriteItem {
+	key := map[string]*dynamodb.AttributeValue{
+		"id":        snapshot["id"],
+		"deletedAt": snapshot["deletedAt"],
+	}
+	if len(remainingLists) == 0 {
+		return &dynamodb.TransactWriteItem{
+			Delete: &dynamodb.Delete{
+				TableName:           aws.String(deletedTable),
+				Key:                 key,
+				ConditionExpression: aws.String("attribute_exists(id)"),
+			},
+		}
+	}
+
+	conditionExpression := "attribute_exists(id) AND attribute_not_exists(#lists)"
+	values := map[string]*dynamodb.AttributeValue{
+		":remaining": {
+			SS: aws.StringSlice(remainingLists),
+		},
+	}
+	if snapshot["lists"] != nil {
+		conditionExpression = "#lists = :lists"
+		values[":lists"] = snapshot["lists"]
+	}
+
+	return &dynamodb.TransactWriteItem{
+		Update: &dynamodb.Update{
+			TableName:           aws.String(deletedTable),
+			Key:                 key,
+			ConditionExpression: aws.String(conditionExpression),
+			UpdateExpression:    aws.String("SET #lists = :remaining"),
+			ExpressionAttributeNames: map[string]*string{
+				"#lists": aws.String("lists"),
+			},
+			ExpressionAttributeValues: values,
+		},
+	}
+}
+
+func isExpired(expiresAt *dynamodb.AttributeValue) bool {
+	if expiresAt == nil || expiresAt.N == nil {
+		return false
+	}
+
+	seconds, err := strconv.ParseInt(*expiresAt.N, 10, 64)
+	return err == nil && time.Now().Unix() > seconds
+}
+
+// restoreLists adds the films of the snapshot lists to the current item. Films from the current item go first,
+// snapshot films already present in the current list or in the opposite one are skipped. Other snapshot
+// attributes are added only when the current item has none of them.
+func restoreLists(current map[string]*dynamodb.AttributeValue, snapshot map[string]*dynamodb.AttributeValue, lists []string) map[string]*dynamodb.AttributeValue {
+	restored := make(map[string]*dynamodb.AttributeValue, len(snapshot)+len(current))
+	for attribute, value := range snapshot {
+		if !slices.Contains(scopeLists["all"], attribute) {
+			restored[attribute] = value
+		}
+	}
+	for attribute, value := range current {
+		restored[attribute] = value
+	}
+
+	for _, list := range lists {
+		if snapshot[list] == nil || snapshot[list].L == nil {
+			continue
+		}
+
+		entries := []*dynamodb.AttributeValue{}
+		if current[list] != nil {
+			entries = append(entries, current[list].L...)
+		}
+		skipped := map[string]bool{}
+		for _, entry := range entries {
+			skipped[strings.ToLower(filmTitle(entry))] = true
+		}
+		if opposite, ok := oppositeLists[list]; ok && current[opposite] != nil {
+			for _, entry := range current[opposite].L {
+				skipped[strings.ToLower(filmTitle(entry))] = true
+			}
+		}
+
+		for _, entry := range snapshot[list].L {
+			title := strings.ToLower(filmTitle(entry))
+			if !skipped[title] {
+				entries = append(entries, entry)
+				skipped[title] = true
+			}
+		}
+		restored[list] = &dynamodb.AttributeValue{L: entries}
+	}
+
+	return restored
+}
+
+// filmTitle returns the title of a liked/unliked list entry, either a legacy plain string or a map with 'title'
+func filmTitle(film *dynamodb.AttributeValue) string {
+	if film.S != nil {
+		return *film.S
+	}
+	if title, ok := film.M["title"]; ok && title.S != nil {
+		return *title.S
+	}
+
+	return ""
+}
+
+// unchangedItemCondition builds a condition that the item is still the same as it was read, or still does not exist
+func unchangedItemCondition(item map[string]*dynamodb.AttributeValue) (string, map[string]*string, map[string]*dynamodb.AttributeValue) {
+	if item == nil {
+		return "attribute_not_exists(id)", nil, nil
+	}
+
+	names := map[string]*string{}
+	values := map[string]*dynamodb.AttributeValue{}
+	conditionExpression := "attribute_exists(id)"
+
+	index := 0
+	for attribute, value := range item {
+		if attribute == "id" {
+			continue
+		}
+
+		name := fmt.Sprintf("#a%d", index)
+		placeholder := fmt.Sprintf(":v%d", index)
+		names[name] = aws.String(attribute)
+		values[placeholder] = value
+		conditionExpression += " AND " + name + " = " + placeholder
+		index++
+	}
+
+	if len(names) == 0 {
+		return conditionExpression, nil, nil
+	}
+	return conditionExpression, names, values
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"finder/common/idempotency"
+	"finder/common/profile"
+	"fmt"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/google/uuid"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var db = dynamodb.New(session.Must(session.NewSession()))
+
+var maxRetries = 3
+var maxBatchSize = 100
+
+func main() {
+	lambda.Start(idempotency.Wrap("update-user-films-batch", handleRequest))
+}
+
+func handleRequest(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	userId, err := getUserIdAndVerify(req)
+	if err != nil {
+		id := req.QueryStringParameters["id"]
+		log.Printf("Provided user id is not correct, user id - %s", id)
+		return events.APIGatewayProxyResponse{
+			StatusCode: 400,
+			Body:       "Provided user id is not correct, user id - " + id,
+		}, nil
+	}
+
+	batch, err := parseBatch(req)
+	if err != nil {
+		log.Printf("Provided ratings are not correct: %v", err)
+		return events.APIGatewayProxyResponse{
+			StatusCode: 400,
+			Body:       "Provided ratings are not correct: " + err.Error(),
+		}, nil
+	}
+
+	outcomes, err := compareAndSetUpdate(userId, batch.Ratings)
+	if err != nil {
+		log.Printf("Got error updating user films: %s", err)
+		return events.APIGatewayProxyResponse{
+			StatusCode: 500,
+			Body:       "Got error updating user films: " + err.Error(),
+		}, nil
+	}
+	if ratedFilms := appliedFilms(outcomes); len(ratedFilms) > 0 {
+		profile.PublishChanged(userId, profile.Rated, ratedFilms)
+	}
+
+	jsonOutcomes, err := json.Marshal(BatchResult{Outcomes: outcomes})
+	if err != nil {
+		log.Printf("Got error parsing to result JSON: %v", outcomes)
+		return events.APIGatewayProxyResponse{
+			StatusCode: 500,
+			Body:       "Got error parsing outcomes to result JSON: " + err.Error(),
+		}, nil
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Body:       string(jsonOutcomes),
+	}, nil
+}
+
+type Batch struct {
+	Ratings []Rating `json:"ratings"`
+}
+
+// Rating is one swipe. RatedAt is the time of the swipe on the client, so swipes queued offline keep their time.
+type Rating struct {
+	Film    string     `json:"film"`
+	Method  string     `json:"method"`
+	RatedAt *time.Time `json:"ratedAt,omitempty"`
+}
+
+type BatchResult struct {
+	Outcomes []Outcome `json:"outcomes"`
+}
+
+// Outcome of every rating in the order of the request: 'applied', also when the film is moved from the other list,
+// 'skipped' when the film is already in the list, or 'invalid' with the reason
+type Outcome struct {
+	Film    string `json:"film"`
+	Method  string `json:"method"`
+	Outcome string `json:"outcome"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+func getUserIdAndVerify(req events.APIGatewayProxyRequest) (string, error) {
+	userId, err := uuid.Parse(req.QueryStringParameters["id"])
+
+	return userId.String(), err
+}
+
+func parseBatch(req events.APIGatewayProxyRequest) (Batch, error) {
+	body := []byte(req.Body)
+	if req.IsBase64Encoded {
+		var err error
+		body, err = base64.StdEncoding.DecodeString(req.Body)
+		if err != nil {
+			return Batch{}, err
+		}
+	}
+
+	var batch Batch
+	err := json.Unmarshal(body, &batch)
+	if err != nil {
+		return Batch{}, err
+	}
+	if len(batch.Ratings) == 0 {
+		return Batch{}, fmt.Errorf("no ratings")
+	}
+	if len(batch.Ratings) > maxBatchSize {
+		return Batch{}, fmt.Errorf("at most %d ratings are accepted at once, got %d", maxBatchSize, len(batch.Ratings))
+	}
+
+	return batch, nil
+}
+
+// compareAndSetUpdate applies all ratings to the lists read once and writes them back with one conditional update,
+// starting over from the read when the lists were changed meanwhile
+func compareAndSetUpdate(userId string, ratings []Rating) ([]Outcome, error) {
+	var err error
+	for attempts := 0; attempts < maxRetries; attempts++ {
+		var result *dynamodb.GetItemOutput
+		result, err = db.GetItem(&dynamodb.GetItemInput{
+			TableName: aws.String("user_films"),
+			Key: map[string]*dynamodb.AttributeValue{
+				"id": {
+					S: aws.String(userId),
+				},
+			},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("got error calling GetItem: %w", err)
+		}
+
+		oldLikedFilms := []*dynamodb.AttributeValue{}
+		oldUnlikedFilms := []*dynamodb.AttributeValue{}
+		if result.Item != nil {
+			if result.Item["likedFilms"] != nil {
+				oldLikedFilms = result.Item["likedFilms"].L
+			}
+			if result.Item["unlikedFilms"] != nil {
+				oldUnlikedFilms = result.Item["unlikedFilms"].L
+			}
+		}
+
+		resultLikedFilms, resultUnlikedFilms, outcomes := applyRatings(oldLikedFilms, oldUnlikedFilms, ratings)
+		if len(appliedFilms(outcomes)) == 0 {
+			return outcomes, nil
+		}
+
+		err = performUpdate(oldLikedFilms, oldUnlikedFilms, resultLikedFilms, resultUnlikedFilms, userId)
+		if err == nil {
+			return outcomes, nil
+		}
+	}
+
+	return nil, fmt.Errorf("got error calling UpdateItem: %w", err)
+}
+
+// applyRatings puts every valid rating in front of its list, the same way single ratings are added one after another.
+// A film is either liked or not liked, so a film rated the other way before is removed from the other list.
+func applyRatings(oldLikedFilms []*dynamodb.AttributeValue, oldUnlikedFilms []*dynamodb.AttributeValue, ratings []Rating) ([]*dynamodb.AttributeValue, []*dynamodb.AttributeValue, []Outcome) {
+	likedFilms := append([]*dynamodb.AttributeValue{}, oldLikedFilms...)
+	unlikedFilms := append([]*dynamodb.AttributeValue{}, oldUnlikedFilms...)
+	likedTitles := filmTitles(oldLikedFilms)
+	unlikedTitles := filmTitles(oldUnlikedFilms)
+
+	outcomes := make([]Outcome, 0, len(ratings))
+	for _, rating := range ratings {
+		outcome := Outcome{Film: rating.Film, Method: rating.Method, Outcome: "applied"}
+		title := strings.ToLower(strings.TrimSpace(rating.Film))
+		ratedAt := time.Now()
+		if rating.RatedAt != nil {
+			ratedAt = *rating.RatedAt
+		}
+
+		if title == "" {
+			outcome.Outcome = "invalid"
+			outcome.Reason = "film is empty"
+		} else if rating.Method == "like" {
+			if likedTitles[title] {
+				outcome.Outcome = "skipped"
+			} else {
+				likedTitles[title] = true
+				likedFilms = append([]*dynamodb.AttributeValue{newFilmEntry(rating.Film, ratedAt)}, likedFilms...)
+				if unlikedTitles[title] {
+					delete(unlikedTitles, title)
+					unlikedFilms = removeFilm(unlikedFilms, title)
+				}
+			}
+		} else if rating.Method == "unlike" {
+			if unlikedTitles[title] {
+				outcome.Outcome = "skipped"
+			} else {
+				unlikedTitles[title] = true
+				unlikedFilms = append([]*dynamodb.AttributeValue{newFilmEntry(rating.Film, ratedAt)}, unlikedFilms...)
+				if likedTitles[title] {
+					delete(likedTitles, title)
+					likedFilms = removeFilm(likedFilms, title)
+				}
+			}
+		} else {
+			outcome.Outcome = "invalid"
+			outcome.Reason = "method must be 'like' or 'unlike'"
+		}
+
+		outcomes = append(outcomes, outcome)
+	}
+
+	return likedFilms, unlikedFilms, outcomes
+}
+
+func appliedFilms(outcomes []Outcome) []string {
+	var films []string
+	for _, outcome := range outcomes {
+		if outcome.Outcome == "applied" {
+			films = append(films, outcome.Film)
+		}
+	}
+
+	return films
+}
+
+func filmTitles(entries []*dynamodb.AttributeValue) map[string]bool {
+	titles := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		if title := filmTitle(entry); title != "" {
+			titles[title] = true
+		}
+	}
+
+	return titles
+}
+
+// filmTitle returns the lower-cased title of a list entry, either a legacy plain string or a map with 'title'
+func filmTitle(entry *dynamodb.AttributeValue) string {
+	if entry.S != nil {
+		return strings.ToLower(*entry.S)
+	}
+	if title, ok := entry.M["title"]; ok && title.S != nil {
+		return strings.ToLower(*title.S)
+	}
+
+	return ""
+}
+
+// removeFilm returns the entries without the film, leaving the given list as it is
+func removeFilm(entries []*dynamodb.AttributeValue, title string) []*dynamodb.AttributeValue {
+	kept := make([]*dynamodb.AttributeValue, 0, len(entries))
+	for _, entry := range entries {
+		if filmTitle(entry) != title {
+			kept = append(kept, entry)
+		}
+	}
+
+	return kept
+}
+
+// newFilmEntry builds a liked/unliked list entry with the time of the rating
+func newFilmEntry(film string, ratedAt time.Time) *dynamodb.AttributeValue {
+	return &dynamodb.AttributeValue{
+		M: map[string]*dynamodb.AttributeValue{
+			"title": {
+				S: aws.String(film),
+			},
+			"ratedAt": {
+				N: aws.String(strconv.FormatInt(ratedAt.Unix(), 10)),
+			},
+		},
+	}
+}
+
+func performUpdate(oldLikedFilms []*dynamodb.AttributeValue, oldUnlikedFilms []*dynamodb.AttributeValue, resultLikedFilms []*dynamodb.AttributeValue, resultUnlikedFilms []*dynamodb.AttributeValue, userId string) error {
+	_, err := db.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String("user_films"),
+		Key: map[string]*dynamodb.AttributeValue{
+			"id": {
+				S: aws.String(userId),
+			},
+		},
+		ConditionExpression: aws.String("(attribute_not_exists(likedFilms) OR likedFilms = :oldLikedFilms) " +
+			"AND (attribute_not_exists(unlikedFilms) OR unlikedFilms = :oldUnlikedFilms)"),
+		UpdateExpression: aws.String("SET likedFilms = :likedFilms, unlikedFilms = :unlikedFilms"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":oldLikedFilms": {
+				L: oldLikedFilms,
+			},
+			":oldUnlikedFilms": {
+				L: oldUnlikedFilms,
+			},
+			":likedFilms": {
+				L: resultLikedFilms,
+			},
+			":unlikedFilms": {
+				L: resultUnlikedFilms,
+			},
+		},
+	})
+
+	return err
+}
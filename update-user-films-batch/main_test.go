@@ -0,0 +1,134 @@
+package main
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"slices"
+	"testing"
+	"time"
+)
+
+func entries(titles ...string) []*dynamodb.AttributeValue {
+	films := make([]*dynamodb.AttributeValue, 0, len(titles))
+	for _, title := range titles {
+		films = append(films, newFilmEntry(title, time.Unix(1700000000, 0)))
+	}
+
+	return films
+}
+
+func entryTitles(films []*dynamodb.AttributeValue) []string {
+	titles := make([]string, 0, len(films))
+	for _, film := range films {
+		titles = append(titles, filmTitle(film))
+	}
+
+	return titles
+}
+
+func TestApplyRatings(t *testing.T) {
+	tests := []struct {
+		name         string
+		likedFilms   []*dynamodb.AttributeValue
+		unlikedFilms []*dynamodb.AttributeValue
+		ratings      []Rating
+		wantLiked    []string
+		wantUnliked  []string
+		wantOutcomes []string
+	}{
+		{
+			name:         "new films go first",
+			likedFilms:   entries("Heat"),
+			unlikedFilms: entries("Cats"),
+			ratings:      []Rating{{Film: "Alien", Method: "like"}, {Film: "Up", Method: "like"}, {Film: "Norbit", Method: "unlike"}},
+			wantLiked:    []string{"up", "alien", "heat"},
+			wantUnliked:  []string{"norbit", "cats"},
+			wantOutcomes: []string{"applied", "applied", "applied"},
+		},
+		{
+			name:         "film already in the list",
+			likedFilms:   entries("Heat"),
+			ratings:      []Rating{{Film: " heat ", Method: "like"}, {Film: "Alien", Method: "like"}, {Film: "ALIEN", Method: "like"}},
+			wantLiked:    []string{"alien", "heat"},
+			wantUnliked:  []string{},
+			wantOutcomes: []string{"skipped", "applied", "skipped"},
+		},
+		{
+			name:         "not liked film is liked",
+			likedFilms:   entries("Heat"),
+			unlikedFilms: entries("Cats", "Alien", "Norbit"),
+			ratings:      []Rating{{Film: "Alien", Method: "like"}},
+			wantLiked:    []string{"alien", "heat"},
+			wantUnliked:  []string{"cats", "norbit"},
+			wantOutcomes: []string{"applied"},
+		},
+		{
+			name:         "liked film is not liked",
+			likedFilms:   entries("Heat", "Alien"),
+			unlikedFilms: entries("Cats"),
+			ratings:      []Rating{{Film: "heat", Method: "unlike"}},
+			wantLiked:    []string{"alien"},
+			wantUnliked:  []string{"heat", "cats"},
+			wantOutcomes: []string{"applied"},
+		},
+		{
+			name: "legacy entry is moved",
+			unlikedFilms: []*dynamodb.AttributeValue{
+				{S: aws.String("Alien")},
+			},
+			ratings:      []Rating{{Film: "Alien", Method: "like"}},
+			wantLiked:    []string{"alien"},
+			wantUnliked:  []string{},
+			wantOutcomes: []string{"applied"},
+		},
+		{
+			name:         "the latest swipe of the batch wins",
+			ratings:      []Rating{{Film: "Alien", Method: "like"}, {Film: "Alien", Method: "unlike"}, {Film: "Alien", Method: "unlike"}},
+			wantLiked:    []string{},
+			wantUnliked:  []string{"alien"},
+			wantOutcomes: []string{"applied", "applied", "skipped"},
+		},
+		{
+			name:         "invalid ratings",
+			likedFilms:   entries("Heat"),
+			ratings:      []Rating{{Film: " ", Method: "like"}, {Film: "Heat", Method: "love"}},
+			wantLiked:    []string{"heat"},
+			wantUnliked:  []string{},
+			wantOutcomes: []string{"invalid", "invalid"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			likedFilms, unlikedFilms, outcomes := applyRatings(test.likedFilms, test.unlikedFilms, test.ratings)
+			if titles := entryTitles(likedFilms); !slices.Equal(titles, test.wantLiked) {
+				t.Errorf("liked films %v, expected %v", titles, test.wantLiked)
+			}
+			if titles := entryTitles(unlikedFilms); !slices.Equal(titles, test.wantUnliked) {
+				t.Errorf("not liked films %v, expected %v", titles, test.wantUnliked)
+			}
+
+			results := make([]string, 0, len(outcomes))
+			for _, outcome := range outcomes {
+				results = append(results, outcome.Outcome)
+			}
+			if !slices.Equal(results, test.wantOutcomes) {
+				t.Errorf("outcomes %v, expected %v", results, test.wantOutcomes)
+			}
+		})
+	}
+}
+
+func TestApplyRatingsKeepsReadLists(t *testing.T) {
+	likedFilms := entries("Heat", "Alien")
+	unlikedFilms := entries("Cats")
+
+	applyRatings(likedFilms, unlikedFilms, []Rating{{Film: "Heat", Method: "unlike"}, {Film: "Cats", Method: "like"}})
+
+	if titles := entryTitles(likedFilms); !slices.Equal(titles, []string{"heat", "alien"}) {
+		t.Errorf("read liked films changed to %v", titles)
+	}
+	if titles := entryTitles(unlikedFilms); !slices.Equal(titles, []string{"cats"}) {
+		t.Errorf("read not liked films changed to %v", titles)
+	}
+}
@@ -9,3 +9,7 @@ require (
 	github.com/google/uuid v1.6.0 // indirect
 	github.com/jmespath/go-jmespath v0.4.0 // indirect
 )
+
+require finder/common v0.0.0
+
+replace finder/common => ../common
@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"finder/common/idempotency"
+	"finder/common/profile"
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go/aws"
@@ -9,23 +11,25 @@ import (
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/google/uuid"
 	"log"
+	"strconv"
+	"time"
 )
 
 var db = dynamodb.New(session.Must(session.NewSession()))
 
 func main() {
-	lambda.Start(handleRequest)
+	lambda.Start(idempotency.Wrap("update-user-films", handleRequest))
 }
 
 func handleRequest(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 	userId, err := getUserIdAndVerify(req)
 	if err != nil {
 		id := req.QueryStringParameters["id"]
-		log.Fatalf("Provided user id is not correct, user id - %s", id)
+		log.Printf("Provided user id is not correct, user id - %s", id)
 		return events.APIGatewayProxyResponse{
 			StatusCode: 400,
 			Body:       "Provided user id is not correct, user id - " + id,
-		}, err
+		}, nil
 	}
 
 	userLikedFilm := []*dynamodb.AttributeValue{}
@@ -34,13 +38,34 @@ func handleRequest(ctx context.Context, req events.APIGatewayProxyRequest) (even
 	method := req.QueryStringParameters["method"]
 	film := req.QueryStringParameters["film"]
 	if method == "like" {
-		userLikedFilm = append(userLikedFilm, &dynamodb.AttributeValue{S: aws.String(film)})
+		userLikedFilm = append(userLikedFilm, newFilmEntry(film, time.Now()))
 	} else if method == "unlike" {
-		userUnlikedFilm = append(userUnlikedFilm, &dynamodb.AttributeValue{S: aws.String(film)})
+		userUnlikedFilm = append(userUnlikedFilm, newFilmEntry(film, time.Now()))
 	}
 
 	maxRetries := 3
-	return compareAndSetUpdate(maxRetries, userId, userLikedFilm, userUnlikedFilm)
+	response, err := compareAndSetUpdate(maxRetries, userId, userLikedFilm, userUnlikedFilm)
+	if response.StatusCode == 204 && method == "like" {
+		profile.PublishChanged(userId, profile.Liked, []string{film})
+	} else if response.StatusCode == 204 && method == "unlike" {
+		profile.PublishChanged(userId, profile.Unliked, []string{film})
+	}
+	return response, err
+}
+
+// newFilmEntry builds a liked/unliked list entry. Entries used to be plain strings,
+// readers still accept both shapes, new entries always carry the time of the rating.
+func newFilmEntry(film string, ratedAt time.Time) *dynamodb.AttributeValue {
+	return &dynamodb.AttributeValue{
+		M: map[string]*dynamodb.AttributeValue{
+			"title": {
+				S: aws.String(film),
+			},
+			"ratedAt": {
+				N: aws.String(strconv.FormatInt(ratedAt.Unix(), 10)),
+			},
+		},
+	}
 }
 
 func performUpdate(oldLikedFilms []*dynamodb.AttributeValue, oldUnlikedFilms []*dynamodb.AttributeValue, resultLikedFilms []*dynamodb.AttributeValue, resultUnlikedFilms []*dynamodb.AttributeValue, userId string) error {
@@ -85,11 +110,11 @@ func compareAndSetUpdate(maxRetries int, userId string, userLikedFilm []*dynamod
 			},
 		})
 		if err != nil {
-			log.Fatalf("Got error calling GetItem: %s", err)
+			log.Printf("Got error calling GetItem: %s", err)
 			return events.APIGatewayProxyResponse{
 				StatusCode: 500,
 				Body:       "Got error calling GetItem: " + err.Error(),
-			}, err
+			}, nil
 		}
 
 		//create or update user liked/unliked films
@@ -112,11 +137,11 @@ func compareAndSetUpdate(maxRetries int, userId string, userLikedFilm []*dynamod
 		if err == nil {
 			break
 		} else if err != nil && attempts == maxRetries-1 {
-			log.Fatalf("Got error calling PutItem: %s", err)
+			log.Printf("Got error calling PutItem: %s", err)
 			return events.APIGatewayProxyResponse{
 				StatusCode: 500,
 				Body:       "Got error calling PutItem: " + err.Error(),
-			}, err
+			}, nil
 		}
 	}
 
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"finder/common/tmdb"
+	"math"
+)
+
+// maxCompatibilityFilms is how many of the latest liked and unliked films of each user are looked up in TMDB
+// for genres and directors. Films rated by both users are compared over the whole lists.
+var maxCompatibilityFilms = 50
+
+// Weights of the parts of the compatibility score
+var (
+	likedFilmsWeight = 0.2
+	agreementWeight  = 0.3
+	genresWeight     = 0.3
+	directorsWeight  = 0.2
+)
+
+// Compatibility is the taste match of two users. Parts are from 0 to 1, the score is from 0 to 100.
+type Compatibility struct {
+	Score int `json:"score"`
+	// LikedFilms is the Jaccard index of liked films
+	LikedFilms float64 `json:"likedFilms"`
+	// Agreement is the share of films rated by both which both liked or both unliked
+	Agreement float64 `json:"agreement"`
+	// Genres and Directors are cosine similarities of taste vectors, negative similarities count as 0
+	Genres           float64  `json:"genres"`
+	Directors        float64  `json:"directors"`
+	CommonFilms      int      `json:"commonFilms"`
+	CommonLikedFilms []string `json:"commonLikedFilms"`
+}
+
+// compatibility compares the rated films and the taste vectors of the users. A part without data, like agreement
+// of users who have no film in common, is left out of the score instead of counting as a mismatch.
+func compatibility(user *UserFilms, friend *UserFilms, userTaste Taste, friendTaste Taste) Compatibility {
+	result := Compatibility{
+		CommonLikedFilms: make([]string, 0),
+	}
+
+	userRatings := ratings(user)
+	friendRatings := ratings(friend)
+	likedUnion := 0
+	likedCommon := 0
+	agreed := 0
+	for title, liked := range userRatings {
+		friendLiked, ok := friendRatings[title]
+		if liked {
+			likedUnion++
+		}
+		if !ok {
+			continue
+		}
+		result.CommonFilms++
+		if liked == friendLiked {
+			agreed++
+		}
+		if liked && friendLiked {
+			likedCommon++
+		}
+	}
+	for _, film := range user.LikedFilms {
+		if friendRatings[normalizeTitle(film.Name)] {
+			result.CommonLikedFilms = append(result.CommonLikedFilms, film.Name)
+		}
+	}
+	for title, liked := range friendRatings {
+		if liked && !userRatings[title] {
+			likedUnion++
+		}
+	}
+
+	var score, weights float64
+	if likedUnion > 0 {
+		result.LikedFilms = float64(likedCommon) / float64(likedUnion)
+		score += likedFilmsWeight * result.LikedFilms
+		weights += likedFilmsWeight
+	}
+	if result.CommonFilms > 0 {
+		result.Agreement = float64(agreed) / float64(result.CommonFilms)
+		score += agreementWeight * result.Agreement
+		weights += agreementWeight
+	}
+
+	if similarity, ok := cosine(userTaste.Genres, friendTaste.Genres); ok {
+		result.Genres = math.Max(similarity, 0)
+		score += genresWeight * result.Genres
+		weights += genresWeight
+	}
+	if similarity, ok := cosine(userTaste.Directors, friendTaste.Directors); ok {
+		result.Directors = math.Max(similarity, 0)
+		score += directorsWeight * result.Directors
+		weights += directorsWeight
+	}
+
+	if weights > 0 {
+		result.Score = int(math.Round(100 * score / weights))
+	}
+	return result
+}
+
+// ratings maps normalized titles to whether the film is liked. A film in both lists counts as liked.
+func ratings(films *UserFilms) map[string]bool {
+	ratings := make(map[string]bool, len(films.LikedFilms)+len(films.UnlikedFilms))
+	for _, film := range films.UnlikedFilms {
+		ratings[normalizeTitle(film.Name)] = false
+	}
+	for _, film := range films.LikedFilms {
+		ratings[normalizeTitle(film.Name)] = true
+	}
+
+	return ratings
+}
+
+// tasteVectors count every genre and director of the latest films, +1 for a liked film and -1 for an unliked one
+func tasteVectors(films *UserFilms) Taste {
+	liked, unliked := latestRatedFilms(films)
+	names := make([]string, 0, len(liked)+len(unliked))
+	for _, film := range liked {
+		names = append(names, film.Name)
+	}
+	for _, film := range unliked {
+		names = append(names, film.Name)
+	}
+	details := tmdb.GetFilmsDetails(names)
+
+	taste := Taste{Genres: map[string]float64{}, Directors: map[string]float64{}}
+	count := func(films []RatedFilm, weight float64) {
+		for _, film := range films {
+			detail, ok := details[film.Name]
+			if !ok {
+				continue
+			}
+			for _, genre := range detail.Genres {
+				taste.Genres[genre] += weight
+			}
+			for _, director := range detail.DirectedBy {
+				taste.Directors[director] += weight
+			}
+		}
+	}
+	count(liked, 1)
+	count(unliked, -1)
+
+	return taste
+}
+
+// latestRatedFilms are the films the taste vectors are counted from
+func latestRatedFilms(films *UserFilms) ([]RatedFilm, []RatedFilm) {
+	return films.LikedFilms[:min(len(films.LikedFilms), maxCompatibilityFilms)],
+		films.UnlikedFilms[:min(len(films.UnlikedFilms), maxCompatibilityFilms)]
+}
+
+// cosine returns false when either vector is zero, as there is nothing to compare
+func cosine(a map[string]float64, b map[string]float64) (float64, bool) {
+	var dot, normA, normB float64
+	for key, value := range a {
+		dot += value * b[key]
+		normA += value * value
+	}
+	for _, value := range b {
+		normB += value * value
+	}
+	if normA == 0 || normB == 0 {
+		return 0, false
+	}
+
+	return dot / math.Sqrt(normA*normB), true
+}
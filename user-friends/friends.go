@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// Friends of every user are kept in 'user_friends': 'friends' is the set of linked users, 'friendRequests' -
+// the users who asked to link and are not answered yet. A link is made when both users added each other.
+var friendsTable = "user_friends"
+
+// Outcomes of adding a friend
+const (
+	friendRequested = "requested"
+	friendLinked    = "linked"
+)
+
+type Friends struct {
+	Friends  []string `json:"friends"`
+	Requests []string `json:"requests"`
+}
+
+func getFriends(userId string) (Friends, error) {
+	result, err := db.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(friendsTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"id": {
+				S: aws.String(userId),
+			},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return Friends{}, err
+	}
+
+	friends := Friends{
+		Friends:  make([]string, 0),
+		Requests: make([]string, 0),
+	}
+	if result.Item["friends"] != nil {
+		friends.Friends = append(friends.Friends, aws.StringValueSlice(result.Item["friends"].SS)...)
+	}
+	if result.Item["friendRequests"] != nil {
+		friends.Requests = append(friends.Requests, aws.StringValueSlice(result.Item["friendRequests"].SS)...)
+	}
+
+	return friends, nil
+}
+
+// addFriend links the users when the friend has asked for it already, otherwise it asks the friend
+func addFriend(userId string, friendId string) (string, error) {
+	_, err := db.TransactWriteItems(&dynamodb.TransactWriteItemsInput{
+		TransactItems: []*dynamodb.TransactWriteItem{
+			{Update: linkUpdate(userId, friendId, aws.String("contains(friendRequests, :friendId)"))},
+			{Update: linkUpdate(friendId, userId, nil)},
+		},
+	})
+	if err == nil {
+		return friendLinked, nil
+	}
+	var awsErr awserr.Error
+	if !errors.As(err, &awsErr) || awsErr.Code() != dynamodb.ErrCodeTransactionCanceledException {
+		return "", err
+	}
+
+	_, err = db.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(friendsTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"id": {
+				S: aws.String(friendId),
+			},
+		},
+		UpdateExpression: aws.String("ADD friendRequests :userId"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":userId": {
+				SS: []*string{aws.String(userId)},
+			},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return friendRequested, nil
+}
+
+// linkUpdate adds the friend to the friends of the user and drops the request of the friend, if there is one
+func linkUpdate(userId string, friendId string, condition *string) *dynamodb.Update {
+	update := &dynamodb.Update{
+		TableName: aws.String(friendsTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"id": {
+				S: aws.String(userId),
+			},
+		},
+		UpdateExpression: aws.String("ADD friends :friend DELETE friendRequests :friend"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":friend": {
+				SS: []*string{aws.String(friendId)},
+			},
+		},
+		ConditionExpression: condition,
+	}
+	if condition != nil {
+		update.ExpressionAttributeValues[":friendId"] = &dynamodb.AttributeValue{
+			S: aws.String(friendId),
+		}
+	}
+
+	return update
+}
+
+// removeFriend unlinks the users and drops requests between them, on both sides
+func removeFriend(userId string, friendId string) error {
+	_, err := db.TransactWriteItems(&dynamodb.TransactWriteItemsInput{
+		TransactItems: []*dynamodb.TransactWriteItem{
+			{Update: unlinkUpdate(userId, friendId)},
+			{Update: unlinkUpdate(friendId, userId)},
+		},
+	})
+	return err
+}
+
+func unlinkUpdate(userId string, friendId string) *dynamodb.Update {
+	return &dynamodb.Update{
+		TableName: aws.String(friendsTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"id": {
+				S: aws.String(userId),
+			},
+		},
+		UpdateExpression: aws.String("DELETE friends :friend, friendRequests :friend"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":friend": {
+				SS: []*string{aws.String(friendId)},
+			},
+		},
+	}
+}
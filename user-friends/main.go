@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"finder/common/idempotency"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/google/uuid"
+	"log"
+	"slices"
+)
+
+var sess = session.Must(session.NewSession())
+var db = dynamodb.New(sess)
+
+func main() {
+	lambda.Start(idempotency.Wrap("user-friends", handleRequest))
+}
+
+// handleRequest serves every action of friends: adding and removing friends, the list of them, compatibility
+// with a friend and liked films of a friend the user has not rated. Films of a user are shown only to friends.
+func handleRequest(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	userId, err := getUserIdAndVerify(req)
+	if err != nil {
+		id := req.QueryStringParameters["id"]
+		log.Printf("Provided user id is not correct, user id - %s", id)
+		return events.APIGatewayProxyResponse{
+			StatusCode: 400,
+			Body:       "Provided user id is not correct, user id - " + id,
+		}, nil
+	}
+
+	friends, err := getFriends(userId)
+	if err != nil {
+		log.Printf("Got error reading friends of user %s: %v", userId, err)
+		return events.APIGatewayProxyResponse{
+			StatusCode: 500,
+			Body:       "Got error calling GetItem: " + err.Error(),
+		}, nil
+	}
+
+	action := req.QueryStringParameters["action"]
+	if action == "list" {
+		return toJsonResponse(200, friends), nil
+	}
+
+	friendId, err := uuid.Parse(req.QueryStringParameters["friendId"])
+	if err != nil || friendId.String() == userId {
+		param := req.QueryStringParameters["friendId"]
+		log.Printf("Provided friend id is not correct, friend id - %s", param)
+		return events.APIGatewayProxyResponse{
+			StatusCode: 400,
+			Body:       "Provided friend id is not correct, friend id - " + param,
+		}, nil
+	}
+
+	switch action {
+	case "add":
+		return handleAdd(userId, friendId.String(), friends), nil
+	case "remove":
+		return handleRemove(userId, friendId.String()), nil
+	case "compatibility", "films":
+		if !slices.Contains(friends.Friends, friendId.String()) {
+			log.Printf("User %s is not a friend of user %s", friendId, userId)
+			return events.APIGatewayProxyResponse{
+				StatusCode: 403,
+				Body:       "User is not a friend, friend id - " + friendId.String(),
+			}, nil
+		}
+		return handleFriendFilms(userId, friendId.String(), action, req), nil
+	}
+
+	log.Printf("Provided action is not correct, action - %s", action)
+	return events.APIGatewayProxyResponse{
+		StatusCode: 400,
+		Body:       "Provided action is not correct, expected 'add', 'remove', 'list', 'compatibility' or 'films', action - " + action,
+	}, nil
+}
+
+func handleAdd(userId string, friendId string, friends Friends) events.APIGatewayProxyResponse {
+	if slices.Contains(friends.Friends, friendId) {
+		return toJsonResponse(200, map[string]string{"status": friendLinked})
+	}
+
+	// a new user has no films yet, so the request is sent to any id, it is kept until the friend answers
+	status, err := addFriend(userId, friendId)
+	if err != nil {
+		log.Printf("Got error adding friend %s of user %s: %v", friendId, userId, err)
+		return events.APIGatewayProxyResponse{
+			StatusCode: 500,
+			Body:       "Got error adding friend: " + err.Error(),
+		}
+	}
+
+	return toJsonResponse(200, map[string]string{"status": status})
+}
+
+func handleRemove(userId string, friendId string) events.APIGatewayProxyResponse {
+	err := removeFriend(userId, friendId)
+	if err != nil {
+		log.Printf("Got error removing friend %s of user %s: %v", friendId, userId, err)
+		return events.APIGatewayProxyResponse{
+			StatusCode: 500,
+			Body:       "Got error calling TransactWriteItems: " + err.Error(),
+		}
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 204,
+	}
+}
+
+func handleFriendFilms(userId string, friendId string, action string, req events.APIGatewayProxyRequest) events.APIGatewayProxyResponse {
+	pagination, err := getPagination(req)
+	if err != nil {
+		log.Printf("Pagination params are not correct: %v", err)
+		return events.APIGatewayProxyResponse{
+			StatusCode: 400,
+			Body:       "Pagination params are not correct: " + err.Error(),
+		}
+	}
+
+	userFilms, err := getUserFilms(userId)
+	if err != nil {
+		log.Printf("Got error calling GetItem: %s", err)
+		return events.APIGatewayProxyResponse{
+			StatusCode: 500,
+			Body:       "Got error calling GetItem: " + err.Error(),
+		}
+	}
+	friendFilms, err := getUserFilms(friendId)
+	if err != nil {
+		log.Printf("Got error calling GetItem: %s", err)
+		return events.APIGatewayProxyResponse{
+			StatusCode: 500,
+			Body:       "Got error calling GetItem: " + err.Error(),
+		}
+	}
+	if userFilms == nil {
+		userFilms = &UserFilms{}
+	}
+	if friendFilms == nil {
+		friendFilms = &UserFilms{}
+	}
+
+	if action == "compatibility" {
+		userTaste := getTaste(userId, userFilms)
+		friendTaste := getTaste(friendId, friendFilms)
+		return toJsonResponse(200, compatibility(userFilms, friendFilms, userTaste, friendTaste))
+	}
+
+	films := unratedLikedFilms(userFilms, friendFilms)
+	page := FriendFilmsPage{TotalCount: len(films)}
+	page.Films, page.NextCursor = paginateFilms(films, pagination)
+	if req.QueryStringParameters["expand"] == "details" {
+		page.Films = expandFilms(page.Films)
+	}
+	return toJsonResponse(200, page)
+}
+
+func toJsonResponse(statusCode int, value any) events.APIGatewayProxyResponse {
+	body, err := json.Marshal(value)
+	if err != nil {
+		log.Printf("Got error parsing result to JSON: %v", err)
+		return events.APIGatewayProxyResponse{
+			StatusCode: 500,
+			Body:       "Got error parsing result to JSON: " + err.Error(),
+		}
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: statusCode,
+		Body:       string(body),
+	}
+}
+
+func getUserIdAndVerify(req events.APIGatewayProxyRequest) (string, error) {
+	userId, err := uuid.Parse(req.QueryStringParameters["id"])
+
+	return userId.String(), err
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-lambda-go/events"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const maxPageSize = 100
+
+// Pagination of friend films works the same way as of get-liked-films: by page number or by cursor
+type Pagination struct {
+	Page  int
+	Size  int
+	After *Cursor
+}
+
+// Cursor points at the last film of the previous page by its name and the time of the like.
+// It is handed to clients as an opaque token.
+type Cursor struct {
+	Name    string `json:"n"`
+	LikedAt int64  `json:"t"`
+}
+
+type FriendFilmsPage struct {
+	Films      []FriendFilm `json:"films"`
+	TotalCount int          `json:"totalCount"`
+	NextCursor string       `json:"nextCursor,omitempty"`
+}
+
+// getPagination reads 'page', 'size' and 'cursor' params. Size defaults to and is capped by maxPageSize.
+func getPagination(req events.APIGatewayProxyRequest) (Pagination, error) {
+	pagination := Pagination{
+		Size: maxPageSize,
+	}
+
+	if sizeString := req.QueryStringParameters["size"]; sizeString != "" {
+		size, err := strconv.Atoi(sizeString)
+		if err != nil || size <= 0 {
+			return Pagination{}, fmt.Errorf("size must be a positive number, size - %s", sizeString)
+		}
+		pagination.Size = min(size, maxPageSize)
+	}
+
+	pageString := req.QueryStringParameters["page"]
+	if pageString != "" {
+		page, err := strconv.Atoi(pageString)
+		if err != nil || page < 0 {
+			return Pagination{}, fmt.Errorf("page must be a non-negative number, page - %s", pageString)
+		}
+		pagination.Page = page
+	}
+
+	if cursorString := req.QueryStringParameters["cursor"]; cursorString != "" {
+		if pageString != "" {
+			return Pagination{}, errors.New("page and cursor can not be used together")
+		}
+
+		cursor, err := decodeCursor(cursorString)
+		if err != nil {
+			return Pagination{}, fmt.Errorf("cursor is not correct - %w", err)
+		}
+		pagination.After = &cursor
+	}
+
+	return pagination, nil
+}
+
+// paginateFilms sorts the films, the latest liked first, takes a page and returns a cursor to the next one
+func paginateFilms(films []FriendFilm, pagination Pagination) ([]FriendFilm, string) {
+	slices.SortFunc(films, compareFilms)
+
+	start := pagination.Page * pagination.Size
+	if pagination.After != nil {
+		after := FriendFilm{Name: pagination.After.Name}
+		if pagination.After.LikedAt != 0 {
+			likedAt := time.Unix(pagination.After.LikedAt, 0).UTC()
+			after.LikedAt = &likedAt
+		}
+		start = sort.Search(len(films), func(i int) bool {
+			return compareFilms(films[i], after) > 0
+		})
+	}
+
+	if start >= len(films) {
+		return make([]FriendFilm, 0), ""
+	}
+
+	end := min(start+pagination.Size, len(films))
+	if end == len(films) {
+		return films[start:end], ""
+	}
+
+	last := films[end-1]
+	return films[start:end], encodeCursor(Cursor{Name: last.Name, LikedAt: likedAtUnix(last)})
+}
+
+// compareFilms orders by the time of the like, the latest first, then by name. Films without time are the oldest.
+func compareFilms(a FriendFilm, b FriendFilm) int {
+	result := cmp.Compare(likedAtUnix(b), likedAtUnix(a))
+	if result == 0 {
+		result = cmp.Compare(a.Name, b.Name)
+	}
+
+	return result
+}
+
+func likedAtUnix(film FriendFilm) int64 {
+	if film.LikedAt == nil {
+		return 0
+	}
+
+	return film.LikedAt.Unix()
+}
+
+func encodeCursor(cursor Cursor) string {
+	bytes, _ := json.Marshal(cursor)
+
+	return base64.RawURLEncoding.EncodeToString(bytes)
+}
+
+func decodeCursor(value string) (Cursor, error) {
+	bytes, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return Cursor{}, err
+	}
+
+	var cursor Cursor
+	err = json.Unmarshal(bytes, &cursor)
+
+	return cursor, err
+}
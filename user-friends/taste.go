@@ -0,0 +1,107 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"log"
+)
+
+// Taste vectors of every user are kept in 'user_friends' as JSON in 'taste', together with 'tasteKey' -
+// the hash of the films they are counted from. They are counted again only when the latest rated films change,
+// so compatibility does not look up films in TMDB on every request.
+// tasteVersion is raised when the vectors are counted another way, stored vectors of other versions are dropped.
+var tasteVersion = "1"
+
+type Taste struct {
+	Genres    map[string]float64 `json:"genres"`
+	Directors map[string]float64 `json:"directors"`
+}
+
+// getTaste returns the stored taste vectors of the user when they are up to date, otherwise counts and stores them.
+// Errors of reading and storing are only logged, the vectors are counted then.
+func getTaste(userId string, films *UserFilms) Taste {
+	key := tasteKey(films)
+	result, err := db.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(friendsTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"id": {
+				S: aws.String(userId),
+			},
+		},
+		ProjectionExpression: aws.String("taste, tasteKey"),
+	})
+	if err != nil {
+		log.Printf("Got error reading taste of user %s: %v", userId, err)
+	} else if taste, ok := parseTaste(result.Item, key); ok {
+		return taste
+	}
+
+	taste := tasteVectors(films)
+	storeTaste(userId, key, taste)
+	return taste
+}
+
+// tasteKey hashes the names of the films the vectors are counted from
+func tasteKey(films *UserFilms) string {
+	liked, unliked := latestRatedFilms(films)
+	hash := sha256.New()
+	hash.Write([]byte(tasteVersion + "\n"))
+	for _, film := range liked {
+		hash.Write([]byte("+" + film.Name + "\n"))
+	}
+	for _, film := range unliked {
+		hash.Write([]byte("-" + film.Name + "\n"))
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func parseTaste(item map[string]*dynamodb.AttributeValue, key string) (Taste, bool) {
+	if item == nil || item["tasteKey"] == nil || item["tasteKey"].S == nil || *item["tasteKey"].S != key {
+		return Taste{}, false
+	}
+	if item["taste"] == nil || item["taste"].S == nil {
+		return Taste{}, false
+	}
+
+	var taste Taste
+	err := json.Unmarshal([]byte(*item["taste"].S), &taste)
+	if err != nil {
+		log.Printf("Got error parsing stored taste, error - %v", err)
+		return Taste{}, false
+	}
+
+	return taste, true
+}
+
+func storeTaste(userId string, key string, taste Taste) {
+	bytes, err := json.Marshal(taste)
+	if err != nil {
+		log.Printf("Got error marshalling taste of user %s: %v", userId, err)
+		return
+	}
+
+	_, err = db.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(friendsTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"id": {
+				S: aws.String(userId),
+			},
+		},
+		UpdateExpression: aws.String("SET taste = :taste, tasteKey = :tasteKey"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":taste": {
+				S: aws.String(string(bytes)),
+			},
+			":tasteKey": {
+				S: aws.String(key),
+			},
+		},
+	})
+	if err != nil {
+		log.Printf("Got error storing taste of user %s: %v", userId, err)
+	}
+}
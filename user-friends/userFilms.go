@@ -0,0 +1,139 @@
+package main
+
+import (
+	"finder/common/tmdb"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+type RatedFilm struct {
+	Name    string     `json:"name"`
+	RatedAt *time.Time `json:"ratedAt,omitempty"`
+}
+
+// UserFilms are the liked and unliked films of the user, newest first
+type UserFilms struct {
+	LikedFilms   []RatedFilm
+	UnlikedFilms []RatedFilm
+}
+
+// getUserFilms returns nil when the user has no films stored
+func getUserFilms(userId string) (*UserFilms, error) {
+	result, err := db.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String("user_films"),
+		Key: map[string]*dynamodb.AttributeValue{
+			"id": {
+				S: aws.String(userId),
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result.Item == nil {
+		return nil, nil
+	}
+
+	userFilms := &UserFilms{}
+	if result.Item["likedFilms"] != nil {
+		userFilms.LikedFilms = toRatedFilms(result.Item["likedFilms"].L)
+	}
+	if result.Item["unlikedFilms"] != nil {
+		userFilms.UnlikedFilms = toRatedFilms(result.Item["unlikedFilms"].L)
+	}
+
+	return userFilms, nil
+}
+
+// toRatedFilms converts stored entries, keeping their order. Legacy plain string entries have no time.
+func toRatedFilms(entries []*dynamodb.AttributeValue) []RatedFilm {
+	films := make([]RatedFilm, 0, len(entries))
+	for _, entry := range entries {
+		if entry.S != nil {
+			films = append(films, RatedFilm{Name: *entry.S})
+			continue
+		}
+
+		title, ok := entry.M["title"]
+		if !ok || title.S == nil {
+			continue
+		}
+		film := RatedFilm{Name: *title.S}
+		if ratedAt, ok := entry.M["ratedAt"]; ok && ratedAt.N != nil {
+			seconds, err := strconv.ParseInt(*ratedAt.N, 10, 64)
+			if err == nil {
+				ratedAtTime := time.Unix(seconds, 0).UTC()
+				film.RatedAt = &ratedAtTime
+			}
+		}
+		films = append(films, film)
+	}
+
+	return films
+}
+
+// ratedTitles returns the normalized titles of all rated films, to compare films of different users
+func (f *UserFilms) ratedTitles() map[string]bool {
+	titles := make(map[string]bool, len(f.LikedFilms)+len(f.UnlikedFilms))
+	for _, film := range f.LikedFilms {
+		titles[normalizeTitle(film.Name)] = true
+	}
+	for _, film := range f.UnlikedFilms {
+		titles[normalizeTitle(film.Name)] = true
+	}
+
+	return titles
+}
+
+// normalizeTitle keeps only letters and digits, titles are compared the same way as in get-films
+func normalizeTitle(title string) string {
+	var normalized strings.Builder
+	for _, r := range strings.ToLower(title) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			normalized.WriteRune(r)
+		}
+	}
+
+	return normalized.String()
+}
+
+type FriendFilm struct {
+	Name    string     `json:"name"`
+	LikedAt *time.Time `json:"likedAt,omitempty"`
+	// filled in only with 'expand=details', has the same fields as a film from get-films
+	*tmdb.ResultRecommendedFilm
+}
+
+// unratedLikedFilms returns liked films of the friend which the user has neither liked nor unliked
+func unratedLikedFilms(user *UserFilms, friend *UserFilms) []FriendFilm {
+	rated := user.ratedTitles()
+	films := make([]FriendFilm, 0)
+	for _, film := range friend.LikedFilms {
+		if !rated[normalizeTitle(film.Name)] {
+			films = append(films, FriendFilm{Name: film.Name, LikedAt: film.RatedAt})
+		}
+	}
+
+	return films
+}
+
+// expandFilms adds TMDB details to the films. Films not found in TMDB are returned without details
+func expandFilms(films []FriendFilm) []FriendFilm {
+	names := make([]string, 0, len(films))
+	for _, film := range films {
+		names = append(names, film.Name)
+	}
+
+	details := tmdb.GetFilmsDetails(names)
+	for index := range films {
+		if detail, ok := details[films[index].Name]; ok {
+			films[index].ResultRecommendedFilm = &detail
+		}
+	}
+
+	return films
+}